@@ -12,9 +12,12 @@ import (
 	"naiveswitcher/internal/updater"
 	"naiveswitcher/pkg/api"
 	"naiveswitcher/pkg/common"
+	"naiveswitcher/pkg/geo"
+	"naiveswitcher/pkg/health"
 	"naiveswitcher/pkg/log"
 	"naiveswitcher/pkg/naive"
 	"naiveswitcher/pkg/proxy"
+	"naiveswitcher/pkg/rules"
 	"naiveswitcher/pkg/subscription"
 	"naiveswitcher/pkg/switcher"
 )
@@ -37,7 +40,7 @@ func init() {
 				d := net.Dialer{
 					Timeout: time.Duration(dnsResolverTimeoutMs) * time.Millisecond,
 				}
-				return d.DialContext(ctx, dnsResolverProto, cfg.DNSResolverIP)
+				return d.DialContext(ctx, dnsResolverProto, cfg.Current().DNSResolverIP)
 			},
 		},
 	}
@@ -54,9 +57,10 @@ func main() {
 	defer stop()
 
 	state := &types.GlobalState{
-		ServerDownPriority: make(map[string]int),
-		AppContext:         ctxWithCancel, // 设置应用程序上下文
-		StartTime:          time.Now().Unix(),
+		AppContext: ctxWithCancel, // 设置应用程序上下文
+		StartTime:  time.Now().Unix(),
+		ErrorRate:  health.NewErrorRate(),
+		Outcomes:   health.NewOutcomes(),
 	}
 
 	// 解析命令行参数
@@ -70,12 +74,44 @@ func main() {
 		panic(err)
 	}
 
+	// 加载配置文件（命令行参数优先）
+	if err := cfg.Load(common.BasePath); err != nil {
+		println(err.Error())
+		return
+	}
+
 	// 验证配置
 	if err := cfg.Validate(); err != nil {
 		println(err.Error())
 		return
 	}
 
+	state.Geo = geo.NewStore(common.BasePath)
+	state.Geo.Load(cfg.Current().Geo)
+	state.Rules = rules.NewEngine(state.Geo)
+	if _, err := state.Rules.Update(cfg.Current().Rules); err != nil {
+		println(err.Error())
+		return
+	}
+
+	// 加载订阅缓存，订阅地址不可用时也能离线启动
+	state.Subscriptions = subscription.NewPool(common.BasePath)
+	if err := state.Subscriptions.LoadCache(cfg.Current().Sources()); err != nil {
+		log.DebugF("Load subscription cache error: %v\n", err)
+	}
+	staticNodes, err := subscription.NewStaticStore(common.BasePath)
+	if err != nil {
+		log.DebugF("Load static nodes error: %v\n", err)
+	}
+	state.StaticNodes = staticNodes
+	healthStore, err := health.NewStore(common.BasePath)
+	if err != nil {
+		log.DebugF("Load health records error: %v\n", err)
+	}
+	state.Health = healthStore
+	state.SetNodes(switcher.CandidateNodes(state, cfg))
+	state.Subscriptions.CheckWarnings(cfg.Current())
+
 	// 尝试加载持久化状态
 	ps, err := types.LoadPersistedState(common.BasePath)
 	if err != nil {
@@ -84,12 +120,13 @@ func main() {
 		state.AutoSwitchMutex.Lock()
 		state.AutoSwitchPaused = ps.AutoSwitchPaused
 		state.LockedServer = ps.LockedServer
+		state.Selector = ps.Selector
 		state.AutoSwitchMutex.Unlock()
 	}
 
 	// 初始化服务器列表
-	if len(state.HostUrls) == 0 {
-		state.HostUrls = append(state.HostUrls, cfg.BootstrapNode)
+	if len(state.NodeList()) == 0 {
+		state.SetNodes([]subscription.Node{{Name: "bootstrap", URL: cfg.Current().BootstrapNode}})
 	}
 
 	state.AutoSwitchMutex.RLock()
@@ -98,25 +135,25 @@ func main() {
 	state.AutoSwitchMutex.RUnlock()
 
 	if paused && locked != "" {
-		if hostUrls, subErr := subscription.Subscription(cfg.SubscribeURL); subErr == nil {
-			state.HostUrls = hostUrls
-		} else {
-			log.DebugF("Error updating subscription: %v\n", subErr)
+		if nodes := switcher.RefreshNodes(state, cfg, true); len(nodes) > 0 {
+			state.SetNodes(nodes)
 		}
-		if restartErr := switcher.RestartNaive(state, locked); restartErr != nil {
+		if restartErr := switcher.RestartNaive(state, cfg, locked); restartErr != nil {
 			log.DebugF("Locked start error: %v\n", restartErr)
 		} else {
 			state.FastestUrl = locked
 		}
 	} else {
-		state.HostUrls, err = switcher.HandleSwitch(state, cfg, state.HostUrls, "")
+		var nodes []subscription.Node
+		nodes, err = switcher.HandleSwitch(state, cfg, state.NodeList(), "")
+		state.SetNodes(nodes)
 		if err != nil {
 			log.DebugF("Bootstrap error: %v (will auto retry)\n", err)
 		}
 	}
 
 	// 启动 TCP 监听
-	l, err := net.Listen("tcp", cfg.ListenPort)
+	l, err := net.Listen("tcp", cfg.Current().ListenPort)
 	if err != nil {
 		panic(err)
 	}
@@ -126,16 +163,46 @@ func main() {
 
 	doSwitch := make(chan types.SwitchRequest, 100)
 	doCheckUpdate := make(chan struct{}, 10)
+	configReloaded := make(chan struct{}, 1)
+
+	go config.Watch(ctxWithCancel, cfg, common.BasePath, configReloaded)
+
+	go geo.Watch(ctxWithCancel, state.Geo, cfg)
 
 	go switcher.Switcher(state, cfg, doSwitch)
 
+	go switcher.Supervise(state, cfg, doSwitch)
+
+	go switcher.ActiveProbe(state, cfg, doSwitch)
+
 	go updater.Updater(state, cfg, gracefulShutdown, doCheckUpdate)
 
 	doCheckUpdate <- struct{}{}
 
 	go func() {
-		ticker := time.NewTicker(time.Duration(cfg.AutoSwitchDuration) * time.Minute)
-		for range ticker.C {
+		interval := cfg.Current().AutoSwitchDuration
+		ticker := time.NewTicker(time.Duration(interval) * time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctxWithCancel.Done():
+				return
+			case <-configReloaded:
+				if changed, err := state.Rules.Update(cfg.Current().Rules); err != nil {
+					log.DebugF("Update rules error: %v\n", err)
+				} else if changed {
+					log.DebugF("Routing rules updated, %d rules\n", len(cfg.Current().Rules))
+				}
+				// 配置重载后按新的间隔重置定时器
+				if next := cfg.Current().AutoSwitchDuration; next != interval {
+					interval = next
+					ticker.Reset(time.Duration(interval) * time.Minute)
+					log.DebugF("Auto switch interval changed to %d minutes\n", interval)
+				}
+				continue
+			case <-ticker.C:
+			}
+
 			state.AutoSwitchMutex.RLock()
 			paused := state.AutoSwitchPaused
 			state.AutoSwitchMutex.RUnlock()
@@ -147,7 +214,7 @@ func main() {
 		}
 	}()
 
-	go proxy.ServeTCP(state, l, doSwitch)
+	go proxy.ServeTCP(state, cfg, l, doSwitch)
 
 	go api.ServeWeb(state, cfg, doSwitch, doCheckUpdate)
 
@@ -164,26 +231,8 @@ func main() {
 	// 3. 给 goroutines 一些时间完成当前操作
 	time.Sleep(500 * time.Millisecond)
 
-	// 4. 最后安全地停止 naive 进程
-	state.NaiveCmdLock.Lock()
-	defer state.NaiveCmdLock.Unlock()
-
-	if state.NaiveCmd != nil {
-		// 先取消 context
-		if state.NaiveCmdCancel != nil {
-			state.NaiveCmdCancel()
-			state.NaiveCmdCancel = nil
-		}
-
-		// 尝试优雅终止进程
-		if state.NaiveCmd.Process != nil {
-			pid := state.NaiveCmd.Process.Pid
-			println("Terminating naive process (PID:", pid, ")")
-			naive.KillProcessGroup(state, pid)
-		}
-
-		state.NaiveCmd = nil
-	}
+	// 4. 最后安全地停止所有 naive 进程（包括排空中的旧进程）
+	switcher.StopAll(state)
 
 	println("Shutdown complete")
 }
@@ -5,10 +5,12 @@ go 1.23.1
 require (
 	github.com/blang/semver v3.5.1+incompatible
 	github.com/google/go-github/v68 v68.0.0
+	github.com/oschwald/maxminddb-golang v1.13.1
 	github.com/prometheus-community/pro-bing v0.6.0
 	github.com/rhysd/go-github-selfupdate v1.2.3
 	github.com/ulikunitz/xz v0.5.12
 	golang.org/x/mod v0.22.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -1,27 +1,358 @@
 package config
 
 import (
+	"bytes"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultConfigFile 默认配置文件名，位于可执行文件同目录
+const DefaultConfigFile = "config.yaml"
+
+// Options 可由配置文件或命令行提供的配置项
+type Options struct {
+	SubscribeURL       string `yaml:"subscribe_url"`
+	ListenPort         string `yaml:"listen"`
+	WebPort            string `yaml:"web"`
+	AutoSwitchDuration int    `yaml:"auto_switch_duration"` // 分钟
+	DNSResolverIP      string `yaml:"dns_resolver"`
+	BootstrapNode      string `yaml:"bootstrap_node"`
+	UpdateRepo         string `yaml:"update_repo"` // GitHub 仓库用于自更新，格式: "owner/repo"
+
+	Subscriptions          []SubscriptionSource `yaml:"subscriptions"`            // 多订阅源，配置后忽略 subscribe_url
+	SubscriptionCacheStale int                  `yaml:"subscription_cache_stale"` // 订阅缓存超过该小时数视为过期，0 表示不检查
+	QuotaWarnPercent       int                  `yaml:"quota_warn_percent"`       // 订阅流量使用超过该百分比时警告，0 表示不检查
+	ExpireWarnDays         int                  `yaml:"expire_warn_days"`         // 订阅距到期不足该天数时警告，0 表示不检查
+	Filters                NodeFilter           `yaml:"filters"`
+	Nodes                  []StaticNode         `yaml:"nodes"`            // 静态节点，始终加入候选
+	Selector               string               `yaml:"selector"`         // 选择策略，默认 score
+	Failover               []string             `yaml:"failover"`         // failover 策略的优先级列表（节点名称、URL 或主机名）
+	HealthHalfLife         int                  `yaml:"health_half_life"` // 故障分的半衰期（小时），0 表示不衰减
+	Hysteresis             Hysteresis           `yaml:"hysteresis"`
+	DrainTimeout           int                  `yaml:"drain_timeout"` // 切换后旧 naive 进程等待连接结束的秒数，0 表示立即停止
+	ReadyTimeout           int                  `yaml:"ready_timeout"` // 新 naive 进程就绪的最长等待秒数，默认 10
+	ReadyTarget            string               `yaml:"ready_target"`  // 就绪检查时经由新进程 CONNECT 的目标，为空时只检查 SOCKS 握手
+	Supervisor             Supervisor           `yaml:"supervisor"`
+	Naive                  NaiveConfig          `yaml:"naive"`           // 传给 naive 的选项
+	UpstreamListen         string               `yaml:"upstream_listen"` // naive 本地 SOCKS 监听地址，端口为 0 时自动分配，也可以是端口范围如 10790-10799
+	ActiveProbe            ActiveProbe          `yaml:"active_probe"`
+	ErrorRate              ErrorRate            `yaml:"error_rate"`
+	Rules                  []Rule               `yaml:"rules"` // 按目标地址路由的规则，未匹配时经由 naive
+	Geo                    Geo                  `yaml:"geo"`
+}
+
+// ErrorRate 按服务器统计滑动窗口内用户连接的失败率，超过阈值时切换
+type ErrorRate struct {
+	Window           int     `yaml:"window"`             // 统计窗口（秒），0 表示不根据连接失败切换
+	Threshold        float64 `yaml:"threshold"`          // 失败率阈值（百分比）
+	MinSamples       int     `yaml:"min_samples"`        // 窗口内连接数达到该值才判断
+	FirstByteTimeout int     `yaml:"first_byte_timeout"` // 客户端发送数据后超过该秒数仍没有收到数据即为失败，0 表示不判断
+}
+
+// ActiveProbe 定期经由当前 naive 进程发送 HTTP 请求，连续失败时主动切换
+type ActiveProbe struct {
+	Interval         int    `yaml:"interval"`          // 探测间隔（秒），0 表示不探测
+	URL              string `yaml:"url"`               // 探测地址
+	ExpectStatus     int    `yaml:"expect_status"`     // 期望的状态码
+	Timeout          int    `yaml:"timeout"`           // 单次探测超时（秒）
+	FailureThreshold int    `yaml:"failure_threshold"` // 连续失败达到该次数后切换
+}
+
+// NaiveConfig 全局和按节点的 naive 选项
+type NaiveConfig struct {
+	NaiveOptions `yaml:",inline"`
+	Nodes        map[string]NaiveOptions `yaml:"nodes"` // 按节点名称、URL 或主机名覆盖全局选项
+}
+
+// NaiveOptions naive 支持的选项，每次启动时写入生成的 config.json
+type NaiveOptions struct {
+	InsecureConcurrency int               `yaml:"insecure_concurrency"` // 每个服务器并发的连接数
+	ExtraHeaders        map[string]string `yaml:"extra_headers"`        // 发送给服务器的额外请求头
+	HostResolverRules   string            `yaml:"host_resolver_rules"`  // 如 "MAP example.com 1.2.3.4"
+	Log                 *string           `yaml:"log"`                  // naive 日志文件，空字符串表示输出到终端，未设置时不记录
+	NoPostQuantum       bool              `yaml:"no_post_quantum"`      // 禁用后量子密钥交换
+}
+
+// Supervisor naive 进程意外退出后的处理
+type Supervisor struct {
+	MaxCrashes int `yaml:"max_crashes"` // 统计窗口内崩溃达到该次数后切换到其他服务器，0 表示只重启
+	Window     int `yaml:"window"`      // 统计窗口（分钟）
+	Backoff    int `yaml:"backoff"`     // 首次重启前等待的秒数，之后翻倍
+}
+
+// Hysteresis 自动切换的迟滞，当前节点正常时只有提升足够大才切换，0 表示不限制
+type Hysteresis struct {
+	MinImprovementPercent float64 `yaml:"min_improvement_percent"` // 评分至少降低的百分比
+	MinImprovementMs      float64 `yaml:"min_improvement_ms"`      // 评分至少降低的毫秒数
+	MinDwell              int     `yaml:"min_dwell"`               // 当前节点至少使用的分钟数
+}
+
+// SubscriptionSource 订阅源
+type SubscriptionSource struct {
+	Name            string `yaml:"name"`
+	URL             string `yaml:"url"`
+	RefreshInterval int    `yaml:"refresh_interval"` // 分钟，0 表示每次切换都刷新
+	Enabled         *bool  `yaml:"enabled"`          // 未设置时默认启用
+
+	Timeout      int               `yaml:"timeout"`       // 单次请求超时（秒），默认 5
+	Retries      int               `yaml:"retries"`       // 网络错误或 5xx 时的重试次数
+	RetryBackoff int               `yaml:"retry_backoff"` // 首次重试间隔（秒），之后翻倍，默认 1
+	UserAgent    string            `yaml:"user_agent"`    // 部分服务商按 User-Agent 返回不同格式
+	Headers      map[string]string `yaml:"headers"`       // 额外请求头
+	FetchMode    string            `yaml:"fetch_mode"`    // direct、tunnel 或 auto，默认 direct
+}
+
+// 内置的选择策略
+const (
+	SelectorScore             = "score"              // 综合评分最低（默认）
+	SelectorLowestLatency     = "lowest_latency"     // 首字节时间最短
+	SelectorHighestThroughput = "highest_throughput" // 下载速度最快
+	SelectorRoundRobin        = "round_robin"        // 依次轮换可用节点
+	SelectorWeightedRandom    = "weighted_random"    // 按评分加权随机
+	SelectorFailover          = "failover"           // 按优先级列表选择第一个可用节点
+)
+
+// Selectors 所有内置选择策略
+var Selectors = []string{
+	SelectorScore,
+	SelectorLowestLatency,
+	SelectorHighestThroughput,
+	SelectorRoundRobin,
+	SelectorWeightedRandom,
+	SelectorFailover,
+}
+
+// 订阅抓取方式
+const (
+	FetchModeDirect = "direct" // 直接访问
+	FetchModeTunnel = "tunnel" // 经由正在运行的 naive SOCKS 上游
+	FetchModeAuto   = "auto"   // 先直连，失败后经由上游
 )
 
+// NodeFilter 节点过滤规则，正则匹配节点名称或主机名
+type NodeFilter struct {
+	Include []string `yaml:"include"` // 为空时不限制
+	Exclude []string `yaml:"exclude"`
+}
+
+// StaticNode 手动配置的节点
+type StaticNode struct {
+	Name     string `yaml:"name" json:"name"`
+	URL      string `yaml:"url" json:"url"`
+	Priority int    `yaml:"priority" json:"priority"` // 优先级加成，抵消等量的故障分
+}
+
+// IsEnabled 订阅源是否启用
+func (s SubscriptionSource) IsEnabled() bool {
+	return s.Enabled == nil || *s.Enabled
+}
+
+// Mode 返回订阅源的抓取方式，未设置时为直连
+func (s SubscriptionSource) Mode() string {
+	if s.FetchMode == "" {
+		return FetchModeDirect
+	}
+	return s.FetchMode
+}
+
+// Sources 返回生效的订阅源列表
+// 未配置 subscriptions 时，将 subscribe_url 作为名为 default 的订阅源
+func (o Options) Sources() []SubscriptionSource {
+	if len(o.Subscriptions) > 0 {
+		return o.Subscriptions
+	}
+	if o.SubscribeURL == "" {
+		return nil
+	}
+	return []SubscriptionSource{{Name: "default", URL: o.SubscribeURL}}
+}
+
+// HealthDecay 故障分的半衰期，0 表示不衰减
+func (o Options) HealthDecay() time.Duration {
+	return time.Duration(o.HealthHalfLife) * time.Hour
+}
+
+// ErrorWindow 连接失败率的统计窗口
+func (o Options) ErrorWindow() time.Duration {
+	return time.Duration(o.ErrorRate.Window) * time.Second
+}
+
+// DrainGrace 切换后旧 naive 进程的排空超时
+func (o Options) DrainGrace() time.Duration {
+	return time.Duration(o.DrainTimeout) * time.Second
+}
+
+// ReadyWait 新 naive 进程就绪的最长等待时间，未设置时为 10 秒
+func (o Options) ReadyWait() time.Duration {
+	if o.ReadyTimeout == 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(o.ReadyTimeout) * time.Second
+}
+
+// For 返回节点生效的 naive 选项，nodes 中按名称、URL 或主机名匹配的选项覆盖全局选项
+// 布尔选项在全局或节点任一处开启即生效，extra_headers 按请求头合并
+func (n NaiveConfig) For(name string, rawURL string) NaiveOptions {
+	opts := n.NaiveOptions
+	var host string
+	if u, err := url.Parse(rawURL); err == nil {
+		host = u.Hostname()
+	}
+	for _, key := range []string{host, name, rawURL} {
+		node, ok := n.Nodes[key]
+		if key == "" || !ok {
+			continue
+		}
+		if node.InsecureConcurrency != 0 {
+			opts.InsecureConcurrency = node.InsecureConcurrency
+		}
+		if len(node.ExtraHeaders) > 0 {
+			headers := make(map[string]string, len(opts.ExtraHeaders)+len(node.ExtraHeaders))
+			maps.Copy(headers, opts.ExtraHeaders)
+			maps.Copy(headers, node.ExtraHeaders)
+			opts.ExtraHeaders = headers
+		}
+		if node.HostResolverRules != "" {
+			opts.HostResolverRules = node.HostResolverRules
+		}
+		if node.Log != nil {
+			opts.Log = node.Log
+		}
+		opts.NoPostQuantum = opts.NoPostQuantum || node.NoPostQuantum
+	}
+	return opts
+}
+
+// UpstreamPorts 解析 upstream_listen，返回监听的主机和端口范围，端口为 0 时由系统分配
+func (o Options) UpstreamPorts() (host string, lo int, hi int, err error) {
+	addr := o.UpstreamListen
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	host, ports, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	if lo, hi, err = ParsePortRange(ports); err != nil {
+		return "", 0, 0, err
+	}
+	return host, lo, hi, nil
+}
+
+// ParsePortRange 解析端口或端口范围如 10790-10799，允许端口 0
+func ParsePortRange(s string) (lo int, hi int, err error) {
+	first, last, isRange := strings.Cut(strings.TrimSpace(s), "-")
+	if lo, err = strconv.Atoi(first); err != nil {
+		return 0, 0, fmt.Errorf("invalid port %q", s)
+	}
+	hi = lo
+	if isRange {
+		if hi, err = strconv.Atoi(last); err != nil {
+			return 0, 0, fmt.Errorf("invalid port %q", s)
+		}
+	}
+	if lo < 0 || hi > 65535 || hi < lo {
+		return 0, 0, fmt.Errorf("invalid port range %q", s)
+	}
+	return lo, hi, nil
+}
+
+// CacheStaleAfter 订阅缓存过期时长，0 表示不检查
+func (o Options) CacheStaleAfter() time.Duration {
+	return time.Duration(o.SubscriptionCacheStale) * time.Hour
+}
+
 // Config 应用配置
+// 配置项通过 Current 读取快照，热重载时整体替换，保证并发读取安全
 type Config struct {
-	SubscribeURL       string
-	ListenPort         string
-	WebPort            string
-	AutoSwitchDuration int
-	DNSResolverIP      string
-	BootstrapNode      string
-	Version            string
-	UpdateRepo         string // GitHub 仓库用于自更新，格式: "owner/repo"
+	Version    string
+	ConfigFile string // 配置文件路径，为空时使用默认路径
+
+	mu   sync.RWMutex
+	opts Options
+
+	flagOpts Options         // 命令行参数的值（含默认值）
+	flagSet  map[string]bool // 用户显式指定的命令行参数
+}
+
+// flagOverrides 命令行参数名到配置项的映射，显式指定的参数覆盖配置文件
+var flagOverrides = map[string]func(dst *Options, src Options){
+	"s": func(dst *Options, src Options) { dst.SubscribeURL = src.SubscribeURL },
+	"l": func(dst *Options, src Options) { dst.ListenPort = src.ListenPort },
+	"w": func(dst *Options, src Options) { dst.WebPort = src.WebPort },
+	"r": func(dst *Options, src Options) { dst.DNSResolverIP = src.DNSResolverIP },
+	"a": func(dst *Options, src Options) { dst.AutoSwitchDuration = src.AutoSwitchDuration },
+	"b": func(dst *Options, src Options) { dst.BootstrapNode = src.BootstrapNode },
+	"u": func(dst *Options, src Options) { dst.UpdateRepo = src.UpdateRepo },
+}
+
+// defaultOptions 没有对应命令行参数的配置项默认值
+func defaultOptions() Options {
+	return Options{
+		UpdateRepo:             "ghostGPT/naiveswitcher", // 默认仓库
+		SubscriptionCacheStale: 24,
+		Selector:               SelectorScore,
+		HealthHalfLife:         6,
+		DrainTimeout:           60,
+		ReadyTimeout:           10,
+		ReadyTarget:            "www.gstatic.com:443",
+		UpstreamListen:         "127.0.0.1:0",
+		Geo: Geo{
+			GeoIPFile:      "Country.mmdb",
+			GeoSiteFile:    "geosite.dat",
+			UpdateInterval: 24,
+		},
+		ErrorRate: ErrorRate{
+			Window:           120,
+			Threshold:        50,
+			MinSamples:       10,
+			FirstByteTimeout: 20,
+		},
+		ActiveProbe: ActiveProbe{
+			Interval:         60,
+			URL:              "http://www.gstatic.com/generate_204",
+			ExpectStatus:     204,
+			Timeout:          10,
+			FailureThreshold: 3,
+		},
+		Supervisor: Supervisor{
+			MaxCrashes: 3,
+			Window:     10,
+			Backoff:    1,
+		},
+		Hysteresis: Hysteresis{
+			MinImprovementPercent: 10,
+			MinImprovementMs:      50,
+			MinDwell:              10,
+		},
+		QuotaWarnPercent: 90,
+		ExpireWarnDays:   7,
+	}
 }
 
 // NewConfig 创建新的配置实例
 func NewConfig(version string) *Config {
 	return &Config{
-		Version:    version,
-		UpdateRepo: "ghostGPT/naiveswitcher", // 默认仓库
+		Version:  version,
+		opts:     defaultOptions(),
+		flagOpts: defaultOptions(),
+		flagSet:  make(map[string]bool),
 	}
 }
 
@@ -29,13 +360,14 @@ func NewConfig(version string) *Config {
 func (c *Config) ParseFlags() bool {
 	var showVersion bool
 
-	flag.StringVar(&c.SubscribeURL, "s", "https://example.com/sublink", "Subscribe to a URL")
-	flag.StringVar(&c.ListenPort, "l", "0.0.0.0:1080", "Listen port")
-	flag.StringVar(&c.WebPort, "w", "0.0.0.0:1081", "Web port")
-	flag.StringVar(&c.DNSResolverIP, "r", "1.0.0.1:53", "DNS resolver IP")
-	flag.IntVar(&c.AutoSwitchDuration, "a", 30, "Auto switch fastest duration (minutes)")
-	flag.StringVar(&c.BootstrapNode, "b", "", "Bootup node (default naive node https://a:b@domain:port)")
-	flag.StringVar(&c.UpdateRepo, "u", "ghostGPT/naiveswitcher", "GitHub repository for self-update (owner/repo)")
+	flag.StringVar(&c.ConfigFile, "c", "", "Config file (YAML or JSON, default config.yaml next to the binary)")
+	flag.StringVar(&c.flagOpts.SubscribeURL, "s", "https://example.com/sublink", "Subscribe to a URL")
+	flag.StringVar(&c.flagOpts.ListenPort, "l", "0.0.0.0:1080", "Listen port")
+	flag.StringVar(&c.flagOpts.WebPort, "w", "0.0.0.0:1081", "Web port")
+	flag.StringVar(&c.flagOpts.DNSResolverIP, "r", "1.0.0.1:53", "DNS resolver IP")
+	flag.IntVar(&c.flagOpts.AutoSwitchDuration, "a", 30, "Auto switch fastest duration (minutes)")
+	flag.StringVar(&c.flagOpts.BootstrapNode, "b", "", "Bootup node (default naive node https://a:b@domain:port)")
+	flag.StringVar(&c.flagOpts.UpdateRepo, "u", "ghostGPT/naiveswitcher", "GitHub repository for self-update (owner/repo)")
 	flag.BoolVar(&showVersion, "v", false, "Show version")
 	flag.Parse()
 
@@ -44,17 +376,441 @@ func (c *Config) ParseFlags() bool {
 		return true
 	}
 
+	flag.Visit(func(f *flag.Flag) {
+		c.flagSet[f.Name] = true
+	})
+
+	c.mu.Lock()
+	c.opts = c.flagOpts
+	c.mu.Unlock()
+
 	return false
 }
 
+// Current 返回当前配置的快照
+func (c *Config) Current() Options {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.opts
+}
+
+// Path 返回实际使用的配置文件路径
+func (c *Config) Path(basePath string) string {
+	if c.ConfigFile != "" {
+		return c.ConfigFile
+	}
+	return filepath.Join(basePath, DefaultConfigFile)
+}
+
+// Load 从配置文件加载配置，命令行显式指定的参数优先
+// 未通过 -c 指定且默认配置文件不存在时，仅使用命令行参数
+func (c *Config) Load(basePath string) error {
+	opts, err := c.build(basePath)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.opts = opts
+	c.mu.Unlock()
+	return nil
+}
+
+// Reload 重新读取配置文件，校验通过后应用可热更新的配置项
+// 返回已应用的变更字段和需要重启才能生效的字段
+func (c *Config) Reload(basePath string) (applied []string, restart []string, err error) {
+	next, err := c.build(basePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := next.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prev := c.opts
+
+	// 监听地址和更新仓库需要重启进程才能生效，保留旧值
+	if next.ListenPort != prev.ListenPort {
+		restart = append(restart, "listen")
+		next.ListenPort = prev.ListenPort
+	}
+	if next.WebPort != prev.WebPort {
+		restart = append(restart, "web")
+		next.WebPort = prev.WebPort
+	}
+	if next.UpdateRepo != prev.UpdateRepo {
+		restart = append(restart, "update_repo")
+		next.UpdateRepo = prev.UpdateRepo
+	}
+
+	if next.SubscribeURL != prev.SubscribeURL {
+		applied = append(applied, "subscribe_url")
+	}
+	if !reflect.DeepEqual(next.Subscriptions, prev.Subscriptions) {
+		applied = append(applied, "subscriptions")
+	}
+	if next.SubscriptionCacheStale != prev.SubscriptionCacheStale {
+		applied = append(applied, "subscription_cache_stale")
+	}
+	if !reflect.DeepEqual(next.Filters, prev.Filters) {
+		applied = append(applied, "filters")
+	}
+	if !reflect.DeepEqual(next.Nodes, prev.Nodes) {
+		applied = append(applied, "nodes")
+	}
+	if next.Selector != prev.Selector || !reflect.DeepEqual(next.Failover, prev.Failover) {
+		applied = append(applied, "selector")
+	}
+	if next.Hysteresis != prev.Hysteresis {
+		applied = append(applied, "hysteresis")
+	}
+	if next.DrainTimeout != prev.DrainTimeout {
+		applied = append(applied, "drain_timeout")
+	}
+	if next.ReadyTimeout != prev.ReadyTimeout || next.ReadyTarget != prev.ReadyTarget {
+		applied = append(applied, "ready")
+	}
+	if next.Supervisor != prev.Supervisor {
+		applied = append(applied, "supervisor")
+	}
+	if next.ErrorRate != prev.ErrorRate {
+		applied = append(applied, "error_rate")
+	}
+	if next.ActiveProbe != prev.ActiveProbe {
+		applied = append(applied, "active_probe")
+	}
+	if next.UpstreamListen != prev.UpstreamListen {
+		applied = append(applied, "upstream_listen")
+	}
+	if !reflect.DeepEqual(next.Naive, prev.Naive) {
+		applied = append(applied, "naive")
+	}
+	if !reflect.DeepEqual(next.Rules, prev.Rules) {
+		applied = append(applied, "rules")
+	}
+	if next.Geo != prev.Geo {
+		applied = append(applied, "geo")
+	}
+	if next.HealthHalfLife != prev.HealthHalfLife {
+		applied = append(applied, "health_half_life")
+	}
+	if next.QuotaWarnPercent != prev.QuotaWarnPercent {
+		applied = append(applied, "quota_warn_percent")
+	}
+	if next.ExpireWarnDays != prev.ExpireWarnDays {
+		applied = append(applied, "expire_warn_days")
+	}
+	if next.AutoSwitchDuration != prev.AutoSwitchDuration {
+		applied = append(applied, "auto_switch_duration")
+	}
+	if next.DNSResolverIP != prev.DNSResolverIP {
+		applied = append(applied, "dns_resolver")
+	}
+	if next.BootstrapNode != prev.BootstrapNode {
+		applied = append(applied, "bootstrap_node")
+	}
+
+	c.opts = next
+	return applied, restart, nil
+}
+
+// build 依次合并默认值、配置文件和显式命令行参数
+func (c *Config) build(basePath string) (Options, error) {
+	opts := c.flagOpts
+
+	path := c.Path(basePath)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && c.ConfigFile == "" {
+			return opts, nil
+		}
+		return Options{}, err
+	}
+
+	if err := decodeOptions(data, &opts); err != nil {
+		return Options{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for name, apply := range flagOverrides {
+		if c.flagSet[name] {
+			apply(&opts, c.flagOpts)
+		}
+	}
+	return opts, nil
+}
+
+// decodeOptions 解析 YAML 或 JSON（JSON 是 YAML 的子集），拒绝未知字段
+func decodeOptions(data []byte, opts *Options) error {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(opts); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// Validate 校验当前配置
 func (c *Config) Validate() error {
-	if c.SubscribeURL == "" {
-		return fmt.Errorf("please provide a subscribe URL")
+	return c.Current().Validate()
+}
+
+// FieldError 单个配置项的校验错误
+type FieldError struct {
+	Path    string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+// ValidationError 汇总所有配置项的校验错误
+type ValidationError []FieldError
+
+func (e ValidationError) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, fe := range e {
+		msgs = append(msgs, fe.Error())
+	}
+	return "invalid config:\n  " + strings.Join(msgs, "\n  ")
+}
+
+// Validate 校验所有配置项，返回全部错误而不是第一个
+func (o Options) Validate() error {
+	var errs ValidationError
+	add := func(path, format string, args ...interface{}) {
+		errs = append(errs, FieldError{Path: path, Message: fmt.Sprintf(format, args...)})
 	}
 
-	if c.AutoSwitchDuration < 30 {
-		return fmt.Errorf("auto switch duration must be at least 30 minutes")
+	if len(o.Subscriptions) == 0 {
+		if o.SubscribeURL == "" {
+			add("subscribe_url", "please provide a subscribe URL")
+		} else if !isValidURL(o.SubscribeURL) {
+			add("subscribe_url", "invalid URL %q", o.SubscribeURL)
+		}
+	}
+
+	names := make(map[string]bool, len(o.Subscriptions))
+	for i, src := range o.Subscriptions {
+		path := fmt.Sprintf("subscriptions[%d]", i)
+		if src.Name == "" {
+			add(path+".name", "must not be empty")
+		} else if names[src.Name] {
+			add(path+".name", "duplicate name %q", src.Name)
+		}
+		names[src.Name] = true
+		if !isValidURL(src.URL) {
+			add(path+".url", "invalid URL %q", src.URL)
+		}
+		if src.RefreshInterval < 0 {
+			add(path+".refresh_interval", "must not be negative")
+		}
+		if src.Timeout < 0 {
+			add(path+".timeout", "must not be negative")
+		}
+		if src.Retries < 0 || src.Retries > 10 {
+			add(path+".retries", "must be between 0 and 10")
+		}
+		if src.RetryBackoff < 0 {
+			add(path+".retry_backoff", "must not be negative")
+		}
+		switch src.FetchMode {
+		case "", FetchModeDirect, FetchModeTunnel, FetchModeAuto:
+		default:
+			add(path+".fetch_mode", "must be one of direct, tunnel, auto")
+		}
+	}
+
+	if o.AutoSwitchDuration < 30 {
+		add("auto_switch_duration", "must be at least 30 minutes")
+	}
+
+	for i, p := range o.Filters.Include {
+		if _, err := regexp.Compile(p); err != nil {
+			add(fmt.Sprintf("filters.include[%d]", i), "invalid regex: %v", err)
+		}
+	}
+	for i, p := range o.Filters.Exclude {
+		if _, err := regexp.Compile(p); err != nil {
+			add(fmt.Sprintf("filters.exclude[%d]", i), "invalid regex: %v", err)
+		}
+	}
+
+	nodeNames := make(map[string]bool, len(o.Nodes))
+	for i, n := range o.Nodes {
+		path := fmt.Sprintf("nodes[%d]", i)
+		if err := n.Validate(); err != nil {
+			add(path, "%v", err)
+		}
+		if nodeNames[n.Name] {
+			add(path+".name", "duplicate name %q", n.Name)
+		}
+		nodeNames[n.Name] = true
+	}
+
+	if o.Selector != "" && !slices.Contains(Selectors, o.Selector) {
+		add("selector", "must be one of %s", strings.Join(Selectors, ", "))
+	}
+	if o.Selector == SelectorFailover && len(o.Failover) == 0 {
+		add("failover", "must not be empty when selector is failover")
 	}
 
+	if o.Hysteresis.MinImprovementPercent < 0 || o.Hysteresis.MinImprovementPercent >= 100 {
+		add("hysteresis.min_improvement_percent", "must be between 0 and 100")
+	}
+	if o.Hysteresis.MinImprovementMs < 0 {
+		add("hysteresis.min_improvement_ms", "must not be negative")
+	}
+	if o.Hysteresis.MinDwell < 0 {
+		add("hysteresis.min_dwell", "must not be negative")
+	}
+	if o.DrainTimeout < 0 {
+		add("drain_timeout", "must not be negative")
+	}
+	if o.ReadyTimeout < 0 {
+		add("ready_timeout", "must not be negative")
+	}
+	if o.ReadyTarget != "" {
+		if _, _, err := net.SplitHostPort(o.ReadyTarget); err != nil {
+			add("ready_target", "invalid address %q", o.ReadyTarget)
+		}
+	}
+	if o.Supervisor.MaxCrashes < 0 {
+		add("supervisor.max_crashes", "must not be negative")
+	}
+	if o.Supervisor.MaxCrashes > 0 && o.Supervisor.Window <= 0 {
+		add("supervisor.window", "must be positive when max_crashes is set")
+	}
+	if o.Supervisor.Backoff < 0 {
+		add("supervisor.backoff", "must not be negative")
+	}
+	if _, lo, hi, err := o.UpstreamPorts(); err != nil {
+		add("upstream_listen", "%v", err)
+	} else if lo != 0 && hi == lo {
+		add("upstream_listen", "needs port 0 or a range of at least 2 ports, old and new naive overlap during a switch")
+	}
+	if o.ErrorRate.Window < 0 {
+		add("error_rate.window", "must not be negative")
+	}
+	if o.ErrorRate.Window > 0 && (o.ErrorRate.Threshold <= 0 || o.ErrorRate.Threshold > 100) {
+		add("error_rate.threshold", "must be between 0 and 100")
+	}
+	if o.ErrorRate.MinSamples < 0 {
+		add("error_rate.min_samples", "must not be negative")
+	}
+	if o.ErrorRate.FirstByteTimeout < 0 {
+		add("error_rate.first_byte_timeout", "must not be negative")
+	}
+	if o.ActiveProbe.Interval < 0 {
+		add("active_probe.interval", "must not be negative")
+	}
+	if o.ActiveProbe.Interval > 0 {
+		if !isValidURL(o.ActiveProbe.URL) {
+			add("active_probe.url", "invalid URL %q", o.ActiveProbe.URL)
+		}
+		if o.ActiveProbe.ExpectStatus < 100 || o.ActiveProbe.ExpectStatus > 599 {
+			add("active_probe.expect_status", "must be an HTTP status code")
+		}
+		if o.ActiveProbe.Timeout <= 0 {
+			add("active_probe.timeout", "must be positive")
+		}
+		if o.ActiveProbe.FailureThreshold <= 0 {
+			add("active_probe.failure_threshold", "must be positive")
+		}
+	}
+	o.Naive.validate("naive", add)
+	for key, node := range o.Naive.Nodes {
+		if key == "" {
+			add("naive.nodes", "node key must not be empty")
+		}
+		node.validate("naive.nodes."+key, add)
+	}
+	for i, rule := range o.Rules {
+		rule.validate(fmt.Sprintf("rules[%d]", i), add)
+	}
+	if o.Geo.GeoIPURL != "" && !isValidURL(o.Geo.GeoIPURL) {
+		add("geo.geoip_url", "invalid URL %q", o.Geo.GeoIPURL)
+	}
+	if o.Geo.GeoSiteURL != "" && !isValidURL(o.Geo.GeoSiteURL) {
+		add("geo.geosite_url", "invalid URL %q", o.Geo.GeoSiteURL)
+	}
+	if o.Geo.UpdateInterval < 0 {
+		add("geo.update_interval", "must not be negative")
+	}
+	if o.HealthHalfLife < 0 {
+		add("health_half_life", "must not be negative")
+	}
+
+	if o.SubscriptionCacheStale < 0 {
+		add("subscription_cache_stale", "must not be negative")
+	}
+	if o.QuotaWarnPercent < 0 || o.QuotaWarnPercent > 100 {
+		add("quota_warn_percent", "must be between 0 and 100")
+	}
+	if o.ExpireWarnDays < 0 {
+		add("expire_warn_days", "must not be negative")
+	}
+
+	if _, _, err := net.SplitHostPort(o.ListenPort); err != nil {
+		add("listen", "invalid address %q", o.ListenPort)
+	}
+	if _, _, err := net.SplitHostPort(o.WebPort); err != nil {
+		add("web", "invalid address %q", o.WebPort)
+	}
+	if _, _, err := net.SplitHostPort(o.DNSResolverIP); err != nil {
+		add("dns_resolver", "invalid address %q", o.DNSResolverIP)
+	}
+
+	if o.BootstrapNode != "" {
+		if u, err := url.Parse(o.BootstrapNode); err != nil || u.Host == "" {
+			add("bootstrap_node", "invalid URL %q", o.BootstrapNode)
+		}
+	}
+
+	if parts := strings.Split(o.UpdateRepo, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		add("update_repo", "must be in owner/repo format")
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func isValidURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && u.Scheme != "" && u.Host != ""
+}
+
+// headerNamePattern HTTP 请求头名称允许的字符
+var headerNamePattern = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+// validate 校验 naive 选项，错误通过 add 汇总
+func (n NaiveOptions) validate(path string, add func(path, format string, args ...interface{})) {
+	if n.InsecureConcurrency < 0 {
+		add(path+".insecure_concurrency", "must not be negative")
+	}
+	for name, value := range n.ExtraHeaders {
+		if !headerNamePattern.MatchString(name) {
+			add(path+".extra_headers", "invalid header name %q", name)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			add(path+".extra_headers", "header %q must not contain line breaks", name)
+		}
+	}
+	if strings.ContainsAny(n.HostResolverRules, "\r\n") {
+		add(path+".host_resolver_rules", "must not contain line breaks")
+	}
+}
+
+// Validate 校验静态节点
+func (n StaticNode) Validate() error {
+	if n.Name == "" {
+		return errors.New("name: must not be empty")
+	}
+	if !isValidURL(n.URL) {
+		return fmt.Errorf("url: invalid URL %q", n.URL)
+	}
 	return nil
 }
@@ -0,0 +1,186 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateReportsAllFields(t *testing.T) {
+	opts := Options{
+		SubscribeURL:       "",
+		ListenPort:         "0.0.0.0:1080",
+		WebPort:            "bad",
+		AutoSwitchDuration: 10,
+		DNSResolverIP:      "1.0.0.1:53",
+		UpdateRepo:         "ghostGPT/naiveswitcher",
+	}
+	err := opts.Validate()
+	var verr ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("expected ValidationError, got %v", err)
+	}
+	paths := make(map[string]bool)
+	for _, fe := range verr {
+		paths[fe.Path] = true
+	}
+	for _, p := range []string{"subscribe_url", "auto_switch_duration", "web"} {
+		if !paths[p] {
+			t.Fatalf("missing error for %s: %v", p, err)
+		}
+	}
+	if len(verr) != 3 {
+		t.Fatalf("unexpected errors: %v", err)
+	}
+}
+
+func TestLoadFlagsOverrideFile(t *testing.T) {
+	base := t.TempDir()
+	data := "subscribe_url: https://file.example.com/sub\nauto_switch_duration: 60\n"
+	if err := os.WriteFile(filepath.Join(base, DefaultConfigFile), []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewConfig("1.0.0")
+	c.flagOpts = Options{SubscribeURL: "https://flag.example.com/sub", AutoSwitchDuration: 30}
+	c.flagSet["s"] = true
+
+	if err := c.Load(base); err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	got := c.Current()
+	if got.SubscribeURL != "https://flag.example.com/sub" {
+		t.Fatalf("flag should override file, got %q", got.SubscribeURL)
+	}
+	if got.AutoSwitchDuration != 60 {
+		t.Fatalf("file should override default, got %d", got.AutoSwitchDuration)
+	}
+}
+
+func TestLoadRejectsUnknownField(t *testing.T) {
+	base := t.TempDir()
+	if err := os.WriteFile(filepath.Join(base, DefaultConfigFile), []byte("unknown_key: 1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c := NewConfig("1.0.0")
+	if err := c.Load(base); err == nil {
+		t.Fatal("expected error for unknown field")
+	}
+}
+
+func TestNaiveOptionsForNode(t *testing.T) {
+	base := t.TempDir()
+	data := `
+naive:
+  insecure_concurrency: 2
+  extra_headers: {X-A: global, X-B: global}
+  nodes:
+    hk1.example.com:
+      insecure_concurrency: 4
+      extra_headers: {X-B: node}
+      no_post_quantum: true
+`
+	if err := os.WriteFile(filepath.Join(base, DefaultConfigFile), []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c := NewConfig("1.0.0")
+	if err := c.Load(base); err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	naive := c.Current().Naive
+
+	got := naive.For("香港 01", "https://u:p@hk1.example.com:443")
+	if got.InsecureConcurrency != 4 || !got.NoPostQuantum || got.ExtraHeaders["X-A"] != "global" || got.ExtraHeaders["X-B"] != "node" {
+		t.Fatalf("unexpected node options: %+v", got)
+	}
+	if other := naive.For("other", "https://u:p@jp1.example.com:443"); other.InsecureConcurrency != 2 || other.NoPostQuantum {
+		t.Fatalf("unexpected global options: %+v", other)
+	}
+	if naive.ExtraHeaders["X-B"] != "global" {
+		t.Fatal("node options modified global headers")
+	}
+
+	if err := os.WriteFile(filepath.Join(base, DefaultConfigFile), []byte("naive:\n  insecure-concurrency: 1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Load(base); err == nil {
+		t.Fatal("expected error for unknown naive option")
+	}
+}
+
+func TestUpstreamPorts(t *testing.T) {
+	cases := []struct {
+		listen string
+		lo, hi int
+		valid  bool
+	}{
+		{"127.0.0.1:0", 0, 0, true},
+		{"", 0, 0, true},
+		{"127.0.0.1:10790-10799", 10790, 10799, true},
+		{"127.0.0.1:10790", 10790, 10790, false},
+		{"127.0.0.1:10799-10790", 0, 0, false},
+		{"127.0.0.1:abc", 0, 0, false},
+	}
+	for _, c := range cases {
+		opts := defaultOptions()
+		opts.UpstreamListen = c.listen
+		_, lo, hi, err := opts.UpstreamPorts()
+		if err == nil && (lo != c.lo || hi != c.hi) {
+			t.Errorf("UpstreamPorts(%q) = %d-%d, want %d-%d", c.listen, lo, hi, c.lo, c.hi)
+		}
+		var verr ValidationError
+		errors.As(opts.Validate(), &verr)
+		invalid := false
+		for _, fe := range verr {
+			invalid = invalid || fe.Path == "upstream_listen"
+		}
+		if invalid == c.valid {
+			t.Errorf("upstream_listen %q valid = %v, want %v", c.listen, !invalid, c.valid)
+		}
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	base := t.TempDir()
+	data := `subscribe_url: https://example.com/sub
+rules:
+  - domain_suffix: [cn]
+    port: [443, 8000-9000]
+    action: direct
+  - ip_cidr: [10.0.0.0/8, 192.168.1.1, bad]
+    domain_regex: ["("]
+    port: [0]
+    geosite: ["@cn"]
+    action: forward
+`
+	if err := os.WriteFile(filepath.Join(base, DefaultConfigFile), []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c := NewConfig("1.0.0")
+	if err := c.Load(base); err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	rules := c.Current().Rules
+	if len(rules) != 2 || len(rules[0].Port) != 2 || rules[0].Port[1] != "8000-9000" {
+		t.Fatalf("unexpected rules: %+v", rules)
+	}
+
+	var verr ValidationError
+	errors.As(c.Validate(), &verr)
+	paths := make(map[string]bool)
+	for _, fe := range verr {
+		paths[fe.Path] = true
+	}
+	for _, p := range []string{"rules[1].action", "rules[1].ip_cidr[2]", "rules[1].domain_regex[0]", "rules[1].port[0]", "rules[1].geosite[0]"} {
+		if !paths[p] {
+			t.Errorf("missing error for %s: %v", p, verr)
+		}
+	}
+	for _, fe := range verr {
+		if strings.HasPrefix(fe.Path, "rules[0]") {
+			t.Errorf("unexpected error: %v", fe)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strings"
+)
+
+// 路由规则的动作
+const (
+	ActionDirect = "direct" // 本机直接连接目标
+	ActionProxy  = "proxy"  // 经由 naive 连接（未匹配任何规则时的默认动作）
+	ActionReject = "reject" // 拒绝连接
+)
+
+// Rule 路由规则，按顺序匹配第一条
+// 设置的条件需要全部满足，同一条件的多个值满足任一即可，没有条件的规则匹配所有连接
+type Rule struct {
+	Name          string   `yaml:"name"`
+	DomainSuffix  []string `yaml:"domain_suffix"`  // 域名或其子域名
+	DomainKeyword []string `yaml:"domain_keyword"` // 域名包含的关键字
+	DomainRegex   []string `yaml:"domain_regex"`   // 匹配域名的正则
+	IPCIDR        []string `yaml:"ip_cidr"`        // IP 或网段，如 10.0.0.0/8
+	Port          []string `yaml:"port"`           // 端口或端口范围，如 443、8000-9000
+	GeoIP         []string `yaml:"geoip"`          // 国家代码，如 cn，private 匹配内网地址
+	GeoSite       []string `yaml:"geosite"`        // geosite.dat 中的分类，如 cn、google@cn
+	Resolve       bool     `yaml:"resolve"`        // 目标为域名时解析后匹配 ip_cidr 和 geoip，否则只匹配 IP 目标
+	Action        string   `yaml:"action"`         // direct、proxy 或 reject
+}
+
+// Geo 离线 GeoIP 和 GeoSite 数据库，相对路径位于程序目录，文件变更后自动重新加载
+type Geo struct {
+	GeoIPFile      string `yaml:"geoip_file"`      // MaxMind 格式的 mmdb，默认 Country.mmdb
+	GeoSiteFile    string `yaml:"geosite_file"`    // v2ray 格式的 geosite.dat，默认 geosite.dat
+	GeoIPURL       string `yaml:"geoip_url"`       // 下载地址，为空时不自动更新
+	GeoSiteURL     string `yaml:"geosite_url"`     // 下载地址，为空时不自动更新
+	UpdateInterval int    `yaml:"update_interval"` // 自动更新的间隔（小时），0 表示不更新
+}
+
+// Label 规则在日志和接口中的名称，未设置 name 时使用序号
+func (r Rule) Label(index int) string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return fmt.Sprintf("rules[%d]", index)
+}
+
+// ParsePrefix 解析网段，单个 IP 视为只包含该地址的网段
+func ParsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		return p.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+}
+
+// validate 校验路由规则，错误通过 add 汇总
+func (r Rule) validate(path string, add func(path, format string, args ...interface{})) {
+	switch r.Action {
+	case ActionDirect, ActionProxy, ActionReject:
+	default:
+		add(path+".action", "must be one of direct, proxy, reject")
+	}
+	for i, s := range r.DomainSuffix {
+		if strings.Trim(s, ".") == "" {
+			add(fmt.Sprintf("%s.domain_suffix[%d]", path, i), "must not be empty")
+		}
+	}
+	for i, s := range r.DomainKeyword {
+		if s == "" {
+			add(fmt.Sprintf("%s.domain_keyword[%d]", path, i), "must not be empty")
+		}
+	}
+	for i, p := range r.DomainRegex {
+		if _, err := regexp.Compile(p); err != nil {
+			add(fmt.Sprintf("%s.domain_regex[%d]", path, i), "invalid regex: %v", err)
+		}
+	}
+	for i, s := range r.IPCIDR {
+		if _, err := ParsePrefix(s); err != nil {
+			add(fmt.Sprintf("%s.ip_cidr[%d]", path, i), "invalid IP or CIDR %q", s)
+		}
+	}
+	for i, s := range r.GeoIP {
+		if s == "" {
+			add(fmt.Sprintf("%s.geoip[%d]", path, i), "must not be empty")
+		}
+	}
+	for i, s := range r.GeoSite {
+		if category, _, _ := strings.Cut(s, "@"); category == "" {
+			add(fmt.Sprintf("%s.geosite[%d]", path, i), "category must not be empty")
+		}
+	}
+	for i, s := range r.Port {
+		if lo, _, err := ParsePortRange(s); err != nil {
+			add(fmt.Sprintf("%s.port[%d]", path, i), "%v", err)
+		} else if lo == 0 {
+			add(fmt.Sprintf("%s.port[%d]", path, i), "must not include port 0")
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"time"
+
+	"naiveswitcher/pkg/log"
+)
+
+// watchInterval 配置文件变更检查间隔
+const watchInterval = 2 * time.Second
+
+// Watch 监听重载信号和配置文件变更，重载成功后通知 reloaded
+func Watch(ctx context.Context, c *Config, basePath string, reloaded chan<- struct{}) {
+	sig := make(chan os.Signal, 1)
+	if signals := reloadSignals(); len(signals) > 0 {
+		signal.Notify(sig, signals...)
+		defer signal.Stop(sig)
+	}
+
+	path := c.Path(basePath)
+	lastMod := fileModTime(path)
+
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sig:
+			log.DebugF("Received reload signal\n")
+		case <-ticker.C:
+			mod := fileModTime(path)
+			if mod.Equal(lastMod) {
+				continue
+			}
+			lastMod = mod
+			log.DebugF("Config file changed: %s\n", path)
+		}
+
+		applied, restart, err := c.Reload(basePath)
+		if err != nil {
+			log.DebugF("Reload config error (keeping previous config): %v\n", err)
+			continue
+		}
+		if len(restart) > 0 {
+			log.DebugF("Config changes require restart to take effect: %v\n", restart)
+		}
+		log.DebugF("Config reloaded, applied: %v\n", applied)
+
+		select {
+		case reloaded <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func fileModTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
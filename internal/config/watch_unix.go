@@ -0,0 +1,12 @@
+//go:build unix
+
+package config
+
+import (
+	"os"
+	"syscall"
+)
+
+func reloadSignals() []os.Signal {
+	return []os.Signal{syscall.SIGHUP}
+}
@@ -0,0 +1,10 @@
+//go:build windows
+
+package config
+
+import "os"
+
+// Windows 没有 SIGHUP，仅依赖文件变更检测
+func reloadSignals() []os.Signal {
+	return nil
+}
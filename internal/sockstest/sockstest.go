@@ -0,0 +1,115 @@
+package sockstest
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+)
+
+// Handler 处理一个已读完 CONNECT 请求的连接，target 为请求的目标地址
+type Handler func(c net.Conn, target string)
+
+// Serve 在随机本地端口上启动 SOCKS5 服务端，测试结束时关闭，返回监听地址
+func Serve(t testing.TB, h Handler) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ServeListener(t, l, h)
+	return l.Addr().String()
+}
+
+// ServeListener 在给定的监听上启动 SOCKS5 服务端，测试结束时关闭
+func ServeListener(t testing.TB, l net.Listener, h Handler) {
+	t.Cleanup(func() { l.Close() })
+	go Run(l, h)
+}
+
+// Run 在给定的监听上处理 SOCKS5 连接，直到监听关闭
+func Run(l net.Listener, h Handler) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func(c net.Conn) {
+			defer c.Close()
+			target, err := handshake(c)
+			if err != nil {
+				return
+			}
+			h(c, target)
+		}(c)
+	}
+}
+
+// Reply 以 code 应答 CONNECT，之后丢弃客户端数据直到连接关闭
+func Reply(code byte) Handler {
+	return func(c net.Conn, target string) {
+		c.Write([]byte{5, code, 0, 1, 0, 0, 0, 0, 0, 0})
+		io.Copy(io.Discard, c)
+	}
+}
+
+// Relay 将连接转发到 target，连接失败时应答 connection refused
+func Relay(c net.Conn, target string) {
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		c.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer upstream.Close()
+	c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	go io.Copy(upstream, c)
+	io.Copy(c, upstream)
+}
+
+// handshake 完成无认证协商并读取 CONNECT 请求，返回目标地址
+func handshake(c net.Conn) (string, error) {
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(c, head); err != nil {
+		return "", err
+	}
+	if _, err := io.ReadFull(c, make([]byte, head[1])); err != nil {
+		return "", err
+	}
+	if _, err := c.Write([]byte{5, 0}); err != nil {
+		return "", err
+	}
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(c, req); err != nil {
+		return "", err
+	}
+	var host string
+	switch req[3] {
+	case 1, 4:
+		ip := make([]byte, net.IPv4len)
+		if req[3] == 4 {
+			ip = make([]byte, net.IPv6len)
+		}
+		if _, err := io.ReadFull(c, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case 3:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(c, n); err != nil {
+			return "", err
+		}
+		name := make([]byte, n[0])
+		if _, err := io.ReadFull(c, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		return "", io.ErrUnexpectedEOF
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(c, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
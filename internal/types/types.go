@@ -3,15 +3,25 @@ package types
 import (
 	"context"
 	"encoding/json"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"naiveswitcher/pkg/geo"
+	"naiveswitcher/pkg/health"
+	"naiveswitcher/pkg/rules"
+	"naiveswitcher/pkg/subscription"
 )
 
 type PersistedState struct {
 	AutoSwitchPaused bool   `json:"auto_switch_paused"`
 	LockedServer     string `json:"locked_server"`
+	Selector         string `json:"selector,omitempty"` // 通过 API 设置的选择策略
 }
 
 const persistedStateFile = "switcher_state.json"
@@ -57,22 +67,186 @@ type SwitchRequest struct {
 	AvoidServer  string // 避免的服务器（用于avoid类型）
 }
 
+// SwitchDecision 一次切换决策的记录，比较当前节点与候选节点的评分
+type SwitchDecision struct {
+	Time           int64   `json:"time"`
+	Selector       string  `json:"selector"`
+	Current        string  `json:"current"`
+	CurrentScore   float64 `json:"current_score"`
+	Candidate      string  `json:"candidate"`
+	CandidateScore float64 `json:"candidate_score"`
+	ImprovementMs  float64 `json:"improvement_ms"`
+	ImprovementPct float64 `json:"improvement_percent"`
+	DwellSeconds   int64   `json:"dwell_seconds"` // 当前节点已使用的时长
+	Switch         bool    `json:"switch"`
+	Reason         string  `json:"reason"`
+}
+
+// NaiveProcess 一个运行中的 naive 进程，每个进程监听独立的本地端口
+type NaiveProcess struct {
+	Cmd       *exec.Cmd
+	Cancel    context.CancelFunc
+	Server    string // 上游节点 URL
+	Addr      string // 本地 SOCKS 监听地址
+	Binary    string // 启动时使用的 naive 可执行文件
+	StartedAt time.Time
+	Conns     atomic.Int32  // 正在使用该进程的连接数
+	DrainAt   time.Time     // 开始排空的时间，受 NaiveCmdLock 保护
+	Done      chan struct{} // 进程退出后关闭
+	ExitErr   error         // Wait 的返回值，Done 关闭后可读
+	Stopping  atomic.Bool   // 由我们主动停止，退出不视为崩溃
+	Config    NaiveConfig   // 启动时生成的 config.json 内容
+}
+
+// NaiveConfig 生成的 naive config.json，字段名与 naive 的命令行参数一致
+type NaiveConfig struct {
+	Listen              string  `json:"listen"`
+	Proxy               string  `json:"proxy"`
+	InsecureConcurrency int     `json:"insecure-concurrency,omitempty"`
+	ExtraHeaders        string  `json:"extra-headers,omitempty"`
+	HostResolverRules   string  `json:"host-resolver-rules,omitempty"`
+	Log                 *string `json:"log,omitempty"`
+	NoPostQuantum       bool    `json:"no-post-quantum,omitempty"`
+}
+
+// Masked 返回隐藏凭据后的配置，用于展示
+func (c NaiveConfig) Masked() NaiveConfig {
+	if u, err := url.Parse(c.Proxy); err == nil {
+		c.Proxy = u.Redacted()
+	}
+	if c.ExtraHeaders != "" {
+		lines := strings.Split(c.ExtraHeaders, "\r\n")
+		for i, line := range lines {
+			name, _, ok := strings.Cut(line, ":")
+			if ok && sensitiveHeaders[strings.ToLower(strings.TrimSpace(name))] {
+				lines[i] = name + ": ***"
+			}
+		}
+		c.ExtraHeaders = strings.Join(lines, "\r\n")
+	}
+	return c
+}
+
+// sensitiveHeaders 展示配置时隐藏值的请求头
+var sensitiveHeaders = map[string]bool{
+	"authorization":       true,
+	"proxy-authorization": true,
+	"cookie":              true,
+}
+
+// Exited 进程是否已退出
+func (p *NaiveProcess) Exited() bool {
+	select {
+	case <-p.Done:
+		return true
+	default:
+		return false
+	}
+}
+
+// NaiveExit naive 进程的一次意外退出
+type NaiveExit struct {
+	PID    int    `json:"pid"`
+	Server string `json:"server"`
+	Code   int    `json:"code"`
+	Error  string `json:"error,omitempty"`
+	Time   int64  `json:"time"`
+}
+
+// SupervisorStatus naive 进程守护的统计
+type SupervisorStatus struct {
+	Restarts        int        `json:"restarts"`          // 意外退出后自动重启的次数
+	RestartFailures int        `json:"restart_failures"`  // 自动重启失败的次数
+	Crashes         int        `json:"crashes_in_window"` // 统计窗口内的意外退出次数
+	LastExit        *NaiveExit `json:"last_exit,omitempty"`
+}
+
+// ActiveProbeStatus 经由当前 naive 进程的主动探测统计
+type ActiveProbeStatus struct {
+	Server              string `json:"server"`
+	Time                int64  `json:"time"` // 最近一次探测的时间戳
+	OK                  bool   `json:"ok"`
+	LatencyMs           int64  `json:"latency_ms"`
+	Error               string `json:"error,omitempty"`
+	Successes           int    `json:"successes"`
+	Failures            int    `json:"failures"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+}
+
+// NaiveStatus naive 进程的状态
+type NaiveStatus struct {
+	PID       int    `json:"pid"`
+	Server    string `json:"server"`
+	Addr      string `json:"addr"`
+	StartedAt int64  `json:"started_at"`
+	Conns     int32  `json:"conns"`
+	Remaining int64  `json:"drain_remaining_seconds,omitempty"` // 距强制结束的秒数
+}
+
+// Status 返回进程状态，grace 为排空的超时时间
+func (p *NaiveProcess) Status(grace time.Duration) NaiveStatus {
+	st := NaiveStatus{
+		Server:    p.Server,
+		Addr:      p.Addr,
+		StartedAt: p.StartedAt.Unix(),
+		Conns:     p.Conns.Load(),
+	}
+	if p.Cmd != nil && p.Cmd.Process != nil {
+		st.PID = p.Cmd.Process.Pid
+	}
+	if !p.DrainAt.IsZero() {
+		st.Remaining = max(int64(time.Until(p.DrainAt.Add(grace)).Seconds()), 0)
+	}
+	return st
+}
+
 // GlobalState 包含全局状态
 // LockedServer: 锁定模式下持久化的节点
 type GlobalState struct {
-	ErrorCount              int32 // 使用 int32 以便使用 atomic 操作
-	NaiveCmd                *exec.Cmd
-	NaiveCmdCancel          context.CancelFunc // naive进程的取消函数
-	NaiveCmdLock            sync.Mutex
-	FastestUrl              string
-	HostUrls                []string
-	ServerDownPriority      map[string]int
-	ServerDownPriorityMutex sync.RWMutex // 保护ServerDownPriority的并发访问
-	AutoSwitchPaused        bool
-	AutoSwitchMutex         sync.RWMutex
-	LockedServer            string
-	AppContext              context.Context // 应用程序上下文，用于控制进程启动
-	StartTime               int64           // 启动时间戳
-	Switching               int32           // 切换中标志，使用 atomic 操作
-	Checking                int32           // 更新检查中标志，使用 atomic 操作
+	ErrorRate        *health.ErrorRate            // 按服务器统计的用户连接失败率
+	Outcomes         *health.Outcomes             // 按服务器和分类累计的用户连接结果
+	Rules            *rules.Engine                // 按目标地址路由的规则
+	Geo              *geo.Store                   // 路由规则使用的 GeoIP 和 GeoSite 数据库
+	Naive            atomic.Pointer[NaiveProcess] // 接收新连接的 naive 进程，读取无需加锁，替换时持有 NaiveCmdLock
+	Draining         []*NaiveProcess              // 等待旧连接结束的 naive 进程，受 NaiveCmdLock 保护
+	NaiveCmdLock     sync.Mutex
+	Retired          []string         // 已被替换、仍有进程在使用的 naive 可执行文件，受 NaiveCmdLock 保护
+	NaiveCrashes     []time.Time      // 统计窗口内意外退出的时间，受 SupervisorMutex 保护
+	Supervisor       SupervisorStatus // 受 SupervisorMutex 保护
+	SupervisorMutex  sync.RWMutex
+	ActiveProbe      ActiveProbeStatus // 受 ActiveProbeMutex 保护
+	ActiveProbeMutex sync.RWMutex
+	FastestUrl       string
+	SwitchedAt       time.Time           // 最近一次启动 naive 的时间，受 NaiveCmdLock 保护
+	Nodes            []subscription.Node // 候选节点，只会整体替换，受 NodesMutex 保护
+	NodesMutex       sync.RWMutex
+	Subscriptions    *subscription.Pool         // 订阅源池，合并多个订阅源
+	StaticNodes      *subscription.StaticStore  // 通过 API 管理的静态节点
+	Health           *health.Store              // 服务器历史健康记录
+	LastProbe        []subscription.ProbeResult // 最近一次测速结果，按评分排序
+	LastProbeTime    int64                      // 最近一次测速时间戳
+	LastDecision     *SwitchDecision            // 最近一次切换决策，受 LastProbeMutex 保护
+	LastProbeMutex   sync.RWMutex
+	AutoSwitchPaused bool
+	AutoSwitchMutex  sync.RWMutex
+	LockedServer     string
+	Selector         string          // 通过 API 设置的选择策略，为空时使用配置，受 AutoSwitchMutex 保护
+	AppContext       context.Context // 应用程序上下文，用于控制进程启动
+	StartTime        int64           // 启动时间戳
+	Switching        int32           // 切换中标志，使用 atomic 操作
+	Checking         int32           // 更新检查中标志，使用 atomic 操作
+}
+
+// NodeList 返回当前的候选节点，调用方不能修改返回的切片
+func (s *GlobalState) NodeList() []subscription.Node {
+	s.NodesMutex.RLock()
+	defer s.NodesMutex.RUnlock()
+	return s.Nodes
+}
+
+// SetNodes 替换候选节点
+func (s *GlobalState) SetNodes(nodes []subscription.Node) {
+	s.NodesMutex.Lock()
+	s.Nodes = nodes
+	s.NodesMutex.Unlock()
 }
@@ -14,7 +14,7 @@ import (
 	"naiveswitcher/pkg/common"
 	"naiveswitcher/pkg/github"
 	"naiveswitcher/pkg/log"
-	"naiveswitcher/pkg/naive"
+	"naiveswitcher/pkg/switcher"
 )
 
 // Updater 处理更新检查
@@ -41,10 +41,10 @@ func Updater(state *types.GlobalState, config *config.Config, gracefulShutdown c
 			}
 
 			log.DebugF("Checking for naive update\n")
-			ctx, cancel := context.WithTimeout(context.Background(), (time.Duration(config.AutoSwitchDuration/2))*time.Minute)
+			ctx, cancel := context.WithTimeout(context.Background(), (time.Duration(config.Current().AutoSwitchDuration/2))*time.Minute)
 			defer cancel()
 
-			latestNaiveVersion, err := github.GitHubCheckGetLatestRelease(ctx, "klzgrad", "naiveproxy", common.Naive)
+			latestNaiveVersion, err := github.GitHubCheckGetLatestRelease(ctx, "klzgrad", "naiveproxy", switcher.NaiveBinary(state))
 			if err != nil {
 				log.DebugF("Error getting latest remote naive version: %v\n", err)
 				return
@@ -60,53 +60,20 @@ func Updater(state *types.GlobalState, config *config.Config, gracefulShutdown c
 				return
 			}
 
-			// 原子性地停止旧进程、更新二进制文件并启动新进程
-			state.NaiveCmdLock.Lock()
-			defer state.NaiveCmdLock.Unlock()
-
-			// 1. 停止当前进程
-			if state.NaiveCmd != nil {
-				// 先取消 context
-				if state.NaiveCmdCancel != nil {
-					state.NaiveCmdCancel()
-					state.NaiveCmdCancel = nil
-				}
-				// 使用 KillProcessGroup 终止进程
-				if state.NaiveCmd.Process != nil {
-					pid := state.NaiveCmd.Process.Pid
-					naive.KillProcessGroup(state, pid)
-				}
-				state.NaiveCmd = nil
-			}
-
-			// 2. 更新二进制文件
-			os.Remove(common.BasePath + "/" + common.Naive)
-			common.Naive = newNaive
-
-			// 3. 启动新进程（检查是否正在关闭）
-			select {
-			case <-state.AppContext.Done():
-				log.DebugF("Application is shutting down, skipping naive restart after update\n")
-				return
-			default:
-			}
-
-			state.NaiveCmd, state.NaiveCmdCancel, err = naive.NaiveCmd(state, state.FastestUrl)
-			if err != nil {
-				log.DebugF("Error creating naive command after update: %v\n", err)
-				return
-			}
-			if err := state.NaiveCmd.Start(); err != nil {
+			// 切换到新版本：新进程就绪后接收新连接，旧进程排空后停止并删除旧版本
+			if err := switcher.UpgradeNaive(state, config, newNaive); err != nil {
 				log.DebugF("Error starting naive after update: %v\n", err)
-				// 如果启动失败，取消 context 释放资源
-				if state.NaiveCmdCancel != nil {
-					state.NaiveCmdCancel()
-					state.NaiveCmdCancel = nil
-				}
-				state.NaiveCmd = nil
+				os.Remove(common.BasePath + "/" + newNaive)
 				return
 			}
-			log.DebugF("Updated to %s (PID: %d)\n", common.Naive, state.NaiveCmd.Process.Pid)
+			log.DebugF("Updated to %s\n", newNaive)
+		}()
+
+		// 更新超过间隔的 GeoIP 和 GeoSite 数据库
+		go func() {
+			ctx, cancel := context.WithTimeout(state.AppContext, 5*time.Minute)
+			defer cancel()
+			state.Geo.Update(ctx, config.Current().Geo)
 		}()
 
 		go func() {
@@ -118,11 +85,11 @@ func Updater(state *types.GlobalState, config *config.Config, gracefulShutdown c
 			default:
 			}
 
-			log.DebugF("Checking for naiveswitcher self-update from repo: %s\n", config.UpdateRepo)
+			log.DebugF("Checking for naiveswitcher self-update from repo: %s\n", config.Current().UpdateRepo)
 			v := semver.MustParse(config.Version)
 			log.DebugF("Current naiveswitcher version: %s\n", config.Version)
 
-			latest, err := selfupdate.UpdateSelf(v, config.UpdateRepo)
+			latest, err := selfupdate.UpdateSelf(v, config.Current().UpdateRepo)
 			if err != nil {
 				log.DebugF("NaiveSwitcher update check failed: %v\n", err)
 				return
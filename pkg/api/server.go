@@ -5,12 +5,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/fs"
+	"math"
 	"math/rand"
 	"net/http"
 	"runtime"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	proping "github.com/prometheus-community/pro-bing"
@@ -20,6 +20,7 @@ import (
 	"naiveswitcher/pkg/common"
 	"naiveswitcher/pkg/log"
 	"naiveswitcher/pkg/subscription"
+	"naiveswitcher/pkg/switcher"
 	"naiveswitcher/util"
 )
 
@@ -41,6 +42,22 @@ func ServeWeb(state *types.GlobalState, config *config.Config, doSwitch chan<- t
 		handleLogsAPI(w, r)
 	})
 
+	http.HandleFunc("/api/nodes", func(w http.ResponseWriter, r *http.Request) {
+		handleNodesAPI(state, config, w, r)
+	})
+
+	http.HandleFunc("/api/events", func(w http.ResponseWriter, r *http.Request) {
+		handleEventsAPI(w, r)
+	})
+
+	http.HandleFunc("/api/selector", func(w http.ResponseWriter, r *http.Request) {
+		handleSelectorAPI(state, config, w, r)
+	})
+
+	http.HandleFunc("/api/naive/config", func(w http.ResponseWriter, r *http.Request) {
+		handleNaiveConfigAPI(state, w, r)
+	})
+
 	http.HandleFunc("/api/auto-switch", func(w http.ResponseWriter, r *http.Request) {
 		handleAutoSwitchAPI(state, w, r)
 	})
@@ -67,18 +84,16 @@ func ServeWeb(state *types.GlobalState, config *config.Config, doSwitch chan<- t
 	}
 	http.Handle("/", http.FileServer(http.FS(webFS)))
 
-	http.ListenAndServe(config.WebPort, nil)
+	http.ListenAndServe(config.Current().WebPort, nil)
 }
 
 func handleSubscription(state *types.GlobalState, config *config.Config, w http.ResponseWriter, _ *http.Request) {
-	newHostUrls, err := subscription.Subscription(config.SubscribeURL)
-	if err != nil {
-		w.Write([]byte(err.Error() + "\n"))
-	} else {
-		state.HostUrls = newHostUrls
+	if newNodes := switcher.RefreshNodes(state, config, true); len(newNodes) > 0 {
+		state.SetNodes(newNodes)
 	}
-	w.Write([]byte(fmt.Sprintf("%d servers in pool\n", len(state.HostUrls))))
-	hostIps := util.BatchLookupURLsIP(state.HostUrls)
+	nodes := state.NodeList()
+	w.Write([]byte(fmt.Sprintf("%d servers in pool\n", len(nodes))))
+	hostIps := util.BatchLookupURLsIP(subscription.URLs(nodes))
 
 	for host, ips := range hostIps {
 		w.Write([]byte(fmt.Sprintf("%s: %+v\n", host, ips.IPs)))
@@ -93,7 +108,7 @@ func handleSubscription(state *types.GlobalState, config *config.Config, w http.
 }
 
 func handlePing(state *types.GlobalState, w http.ResponseWriter, _ *http.Request) {
-	hostIps := util.BatchLookupURLsIP(state.HostUrls)
+	hostIps := util.BatchLookupURLsIP(subscription.URLs(state.NodeList()))
 	uniqueIps := util.UniqueIPs(hostIps)
 	uniqueHosts := make(map[string]struct{})
 	for _, hosts := range uniqueIps {
@@ -128,7 +143,7 @@ func handleSwitchAPI(state *types.GlobalState, w http.ResponseWriter, r *http.Re
 
 	var req struct {
 		Type         string `json:"type"`          // "auto", "avoid", "select"
-		TargetServer string `json:"target_server"` // for "select" type
+		TargetServer string `json:"target_server"` // for "select" type, node URL or name
 		AvoidServer  string `json:"avoid_server"`  // for "avoid" type
 	}
 
@@ -164,31 +179,75 @@ func handleStatusAPI(state *types.GlobalState, config *config.Config, w http.Res
 
 	uptime := formatUptime(time.Since(time.Unix(state.StartTime, 0)))
 
-	// 复制 ServerDownPriority map 需要加锁
-	state.ServerDownPriorityMutex.RLock()
-	downStatsCopy := make(map[string]int, len(state.ServerDownPriority))
-	for k, v := range state.ServerDownPriority {
-		downStatsCopy[k] = v
+	nodes := state.NodeList()
+	var currentName string
+	if node, ok := subscription.FindNode(nodes, state.FastestUrl); ok {
+		currentName = node.Name
+	}
+
+	// 故障统计来自健康记录，保留 down_stats 兼容旧的接口
+	healthRecords := state.Health.Snapshot(config.Current().HealthDecay())
+	downStats := make(map[string]float64)
+	for _, r := range healthRecords {
+		if r.FailureScore > 0 {
+			downStats[r.Host] = math.Round(r.FailureScore*100) / 100
+		}
+	}
+
+	state.LastProbeMutex.RLock()
+	probe := map[string]interface{}{
+		"time":     state.LastProbeTime,
+		"results":  state.LastProbe,
+		"decision": state.LastDecision,
+	}
+	state.LastProbeMutex.RUnlock()
+
+	activeNaive, drainingNaive := switcher.NaiveStatus(state, config)
+
+	opts := config.Current()
+	errorRate := map[string]interface{}{
+		"window_seconds":    opts.ErrorRate.Window,
+		"threshold_percent": opts.ErrorRate.Threshold,
+		"min_samples":       opts.ErrorRate.MinSamples,
+		"servers":           state.ErrorRate.Snapshot(opts.ErrorWindow()),
+	}
+
+	ruleStats, unmatched := state.Rules.Snapshot()
+	routing := map[string]interface{}{
+		"rules":     ruleStats,
+		"unmatched": unmatched,
 	}
-	state.ServerDownPriorityMutex.RUnlock()
 
 	// Get runtime metrics
 	var memStats runtime.MemStats
 	runtime.ReadMemStats(&memStats)
 
 	data := map[string]interface{}{
-		"current_server":     state.FastestUrl,
-		"error_count":        atomic.LoadInt32(&state.ErrorCount),
-		"down_stats":         downStatsCopy,
-		"naive_version":      common.Naive,
-		"switcher_version":   config.Version,
-		"auto_switch_paused": paused,
-		"available_servers":  state.HostUrls,
-		"uptime":             uptime,
-		"start_time":         state.StartTime,
-		"goroutine_count":    runtime.NumGoroutine(),
-		"memory_usage_mb":    fmt.Sprintf("%.2f", float64(memStats.Sys)/1024/1024),
-		"memory_alloc_mb":    fmt.Sprintf("%.2f", float64(memStats.Alloc)/1024/1024),
+		"current_server":      state.FastestUrl,
+		"current_server_name": currentName,
+		"error_rate":          errorRate,
+		"outcomes":            state.Outcomes.Snapshot(),
+		"rules":               routing,
+		"geo":                 state.Geo.Status(),
+		"down_stats":          downStats,
+		"health":              healthRecords,
+		"naive_version":       switcher.NaiveBinary(state),
+		"switcher_version":    config.Version,
+		"auto_switch_paused":  paused,
+		"available_servers":   subscription.URLs(nodes),
+		"nodes":               nodes,
+		"subscriptions":       state.Subscriptions.Status(config.Current().CacheStaleAfter()),
+		"probe":               probe,
+		"selector":            switcher.SelectorName(state, config),
+		"naive":               activeNaive,
+		"draining":            drainingNaive,
+		"supervisor":          switcher.SupervisorStatus(state),
+		"active_probe":        switcher.ActiveProbeStatus(state),
+		"uptime":              uptime,
+		"start_time":          state.StartTime,
+		"goroutine_count":     runtime.NumGoroutine(),
+		"memory_usage_mb":     fmt.Sprintf("%.2f", float64(memStats.Sys)/1024/1024),
+		"memory_alloc_mb":     fmt.Sprintf("%.2f", float64(memStats.Alloc)/1024/1024),
 	}
 
 	writeJSONSuccess(w, data)
@@ -205,6 +264,103 @@ func handleLogsAPI(w http.ResponseWriter, r *http.Request) {
 	log.WriteLog(w)
 }
 
+// handleNodesAPI 静态节点的增删改查
+// GET 列出所有静态节点，POST 添加，PUT 按名称更新，DELETE 按名称（?name=）删除
+func handleNodesAPI(state *types.GlobalState, cfg *config.Config, w http.ResponseWriter, r *http.Request) {
+	configNodes := cfg.Current().Nodes
+
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		writeJSONSuccess(w, state.StaticNodes.List(configNodes))
+		return
+	case http.MethodPost, http.MethodPut:
+		var node config.StaticNode
+		if decodeErr := json.NewDecoder(r.Body).Decode(&node); decodeErr != nil {
+			writeJSONError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if r.Method == http.MethodPost {
+			err = state.StaticNodes.Add(configNodes, node)
+		} else {
+			err = state.StaticNodes.Update(configNodes, node)
+		}
+	case http.MethodDelete:
+		err = state.StaticNodes.Delete(configNodes, r.URL.Query().Get("name"))
+	default:
+		writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// 立即更新候选节点，无需等待下一次订阅刷新
+	state.SetNodes(switcher.CandidateNodes(state, cfg))
+
+	writeJSONSuccess(w, state.StaticNodes.List(configNodes))
+}
+
+// handleEventsAPI 返回最近的警告事件
+func handleEventsAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSONSuccess(w, log.Events())
+}
+
+// handleSelectorAPI 查看或运行时切换选择策略
+// POST 的 strategy 为空时恢复使用配置文件中的策略
+func handleSelectorAPI(state *types.GlobalState, cfg *config.Config, w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			Strategy string `json:"strategy"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := switcher.SetSelector(state, cfg, req.Strategy); err != nil {
+			writeJSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.DebugF("Selector changed to %s\n", switcher.SelectorName(state, cfg))
+	default:
+		writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSONSuccess(w, map[string]interface{}{
+		"strategy":   switcher.SelectorName(state, cfg),
+		"available":  config.Selectors,
+		"configured": cfg.Current().Selector,
+	})
+}
+
+// handleNaiveConfigAPI 返回当前 naive 进程生效的配置，隐藏凭据
+func handleNaiveConfigAPI(state *types.GlobalState, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	p := state.Naive.Load()
+	if p == nil {
+		writeJSONError(w, "naive is not running", http.StatusNotFound)
+		return
+	}
+	writeJSONSuccess(w, map[string]interface{}{
+		"pid":    p.Cmd.Process.Pid,
+		"config": p.Config.Masked(),
+	})
+}
+
 // handleAutoSwitchAPI 处理自动切换的暂停/恢复
 func handleAutoSwitchAPI(state *types.GlobalState, w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -240,6 +396,7 @@ func handleAutoSwitchAPI(state *types.GlobalState, w http.ResponseWriter, r *htt
 	ps := types.PersistedState{
 		AutoSwitchPaused: state.AutoSwitchPaused,
 		LockedServer:     state.LockedServer,
+		Selector:         state.Selector,
 	}
 	state.AutoSwitchMutex.Unlock()
 
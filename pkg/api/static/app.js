@@ -13,6 +13,7 @@ function updateCountdown() {
     if (countdown <= 0) {
         countdown = 3;
         fetchStatus();
+        fetchEvents();
     } else {
         countdown--;
     }
@@ -33,22 +34,126 @@ async function fetchStatus() {
     }
 }
 
+// Fetch warning events from API
+async function fetchEvents() {
+    try {
+        const response = await fetch('/api/events');
+        const result = await response.json();
+
+        if (result.success) {
+            renderEvents(result.data || []);
+        }
+    } catch (error) {
+        console.error('Error fetching events:', error);
+    }
+}
+
+// Render warning events
+function renderEvents(events) {
+    const eventsEl = document.getElementById('events');
+    if (!eventsEl) return;
+    if (events.length === 0) {
+        eventsEl.textContent = '暂无警告';
+        return;
+    }
+    eventsEl.textContent = events.map(e =>
+        `[${new Date(e.time).toLocaleString()}] ${e.kind}: ${e.message}`
+    ).join('\n');
+}
+
+// Render subscription sources with quota and expiry
+function renderSubscriptions(subscriptions) {
+    const subsEl = document.getElementById('subscriptions');
+    if (!subsEl) return;
+    if (subscriptions.length === 0) {
+        subsEl.textContent = '未配置订阅源';
+        return;
+    }
+    subsEl.innerHTML = '';
+    subscriptions.forEach(sub => {
+        const parts = [sub.enabled ? `${sub.host_count} 个节点` : '已禁用'];
+        let level = sub.last_error ? 'warning-text' : 'success-text';
+        if (sub.userinfo) {
+            if (sub.userinfo.total > 0) {
+                parts.push(`已用 ${sub.userinfo.used_percent.toFixed(1)}%`);
+            }
+            if (sub.userinfo.expire > 0) {
+                parts.push(`剩余 ${Math.max(0, sub.userinfo.days_until_expiry || 0).toFixed(1)} 天`);
+            }
+        }
+        if (sub.fetch_mode === 'tunnel') {
+            parts.push('经由隧道');
+        }
+        if (sub.stale) {
+            parts.push('缓存已过期');
+            level = 'error-text';
+        }
+        if (sub.last_error) {
+            parts.push('抓取失败');
+        }
+
+        const row = document.createElement('div');
+        row.className = 'metric';
+        const label = document.createElement('span');
+        label.className = 'metric-label';
+        label.textContent = sub.name;
+        label.title = sub.last_error || '';
+        const value = document.createElement('span');
+        value.className = 'metric-value ' + level;
+        value.textContent = parts.join(' · ');
+        row.appendChild(label);
+        row.appendChild(value);
+        subsEl.appendChild(row);
+    });
+}
+
 // Update UI with current data
+function renderProbe(probe, nodes) {
+    const probeEl = document.getElementById('probe');
+    if (!probeEl) return;
+    const results = (probe && probe.results) || [];
+    if (results.length === 0) {
+        probeEl.textContent = '暂无测速结果';
+        return;
+    }
+    const names = {};
+    nodes.forEach(node => { names[node.url] = node.name; });
+    const lines = [`测速时间: ${new Date(probe.time * 1000).toLocaleString()}`];
+    const d = probe.decision;
+    if (d) {
+        const candidate = names[d.candidate] || d.candidate;
+        lines.push(`切换决策: ${d.switch ? '切换' : '保持'} (${d.reason}) · 候选 ${candidate} · 评分 ${d.current_score.toFixed(0)} → ${d.candidate_score.toFixed(0)}`);
+    }
+    results.forEach(r => {
+        const name = names[r.url] || r.host;
+        if (!r.ok) {
+            lines.push(`${name}: 失败 (${r.error})`);
+            return;
+        }
+        lines.push(`${name}: 评分 ${r.score.toFixed(0)} · 连接 ${r.connect_ms.toFixed(0)}ms · TLS ${r.tls_ms.toFixed(0)}ms · 首字节 ${r.ttfb_ms.toFixed(0)}ms · ${r.throughput_kbps.toFixed(0)}KB/s · 故障 ${r.failures}`);
+    });
+    probeEl.textContent = lines.join('\n');
+}
+
 function updateUI() {
     const data = currentData || {};
 
     // Current server
     const currentServerEl = document.getElementById('current-server');
     if (currentServerEl) {
-        currentServerEl.textContent = data.current_server || '未知';
+        currentServerEl.textContent = data.current_server_name || data.current_server || '未知';
+        currentServerEl.title = data.current_server || '';
     }
 
     // Status indicator
     const indicator = document.getElementById('server-status-indicator');
-    const errorCount = data.error_count || 0;
+    const errorRate = data.error_rate || {};
+    const currentErrors = (errorRate.servers || []).find(s => s.server === data.current_server) ||
+        {total: 0, failures: 0, rate: 0};
+    const errorLevel = currentErrors.failures === 0 ? 'online' :
+        currentErrors.rate >= (errorRate.threshold_percent || 50) / 2 ? 'error' : 'warning';
     if (indicator) {
-        indicator.className = 'status-indicator ' +
-            (errorCount > 5 ? 'error' : errorCount > 0 ? 'warning' : 'online');
+        indicator.className = 'status-indicator ' + errorLevel;
     }
 
     // Uptime
@@ -57,6 +162,39 @@ function updateUI() {
         uptimeEl.textContent = data.uptime || '--';
     }
 
+    // Naive processes
+    const naiveConnsEl = document.getElementById('naive-conns');
+    if (naiveConnsEl) {
+        naiveConnsEl.textContent = data.naive ? `${data.naive.conns}` : '未运行';
+    }
+    const drainingEl = document.getElementById('naive-draining');
+    if (drainingEl) {
+        const draining = data.draining || [];
+        drainingEl.textContent = draining.length === 0 ? '无' : draining
+            .map(p => `PID ${p.pid}: ${p.conns} 个连接，${p.drain_remaining_seconds || 0} 秒后结束`)
+            .join('；');
+    }
+    const supervisorEl = document.getElementById('naive-supervisor');
+    if (supervisorEl) {
+        const sv = data.supervisor || {};
+        let text = `${sv.restarts || 0} 次，失败 ${sv.restart_failures || 0} 次，窗口内崩溃 ${sv.crashes_in_window || 0} 次`;
+        if (sv.last_exit) {
+            text += `（上次退出码 ${sv.last_exit.code}，${new Date(sv.last_exit.time * 1000).toLocaleString()}）`;
+        }
+        supervisorEl.textContent = text;
+    }
+    const activeProbeEl = document.getElementById('active-probe');
+    if (activeProbeEl) {
+        const ap = data.active_probe || {};
+        if (!ap.time) {
+            activeProbeEl.textContent = '尚未探测';
+        } else if (ap.ok) {
+            activeProbeEl.textContent = `正常 ${ap.latency_ms}ms（成功 ${ap.successes} / 失败 ${ap.failures}）`;
+        } else {
+            activeProbeEl.textContent = `连续失败 ${ap.consecutive_failures} 次：${ap.error || ''}`;
+        }
+    }
+
     // Auto switch status
     autoSwitchPaused = data.auto_switch_paused || false;
     const autoSwitchStatus = document.getElementById('auto-switch-status');
@@ -79,9 +217,43 @@ function updateUI() {
     // Error count
     const errorCountEl = document.getElementById('error-count');
     if (errorCountEl) {
-        errorCountEl.textContent = errorCount;
+        errorCountEl.textContent = `${currentErrors.rate.toFixed(1)}%（${currentErrors.failures}/${currentErrors.total}，最近 ${errorRate.window_seconds || 0} 秒）`;
         errorCountEl.className = 'metric-value ' +
-            (errorCount > 5 ? 'error-text' : errorCount > 0 ? 'warning-text' : 'success-text');
+            (errorLevel === 'error' ? 'error-text' : errorLevel === 'warning' ? 'warning-text' : 'success-text');
+    }
+
+    // Connection outcomes of the current server
+    const outcomesEl = document.getElementById('outcomes');
+    if (outcomesEl) {
+        const outcomes = (data.outcomes || {})[data.current_server] || {};
+        const entries = Object.entries(outcomes).sort((a, b) => b[1] - a[1]);
+        outcomesEl.textContent = entries.length === 0 ? '--' :
+            entries.map(([kind, n]) => `${kind} ${n}`).join(' · ');
+    }
+
+    // Routing rule hits
+    const ruleHitsEl = document.getElementById('rule-hits');
+    if (ruleHitsEl) {
+        const routing = data.rules || {};
+        const hits = (routing.rules || []).filter(r => r.hits > 0)
+            .map(r => `${r.name}（${r.action}）${r.hits}`);
+        if (routing.unmatched) {
+            hits.push(`未匹配 ${routing.unmatched}`);
+        }
+        ruleHitsEl.textContent = hits.length === 0 ? '--' : hits.join(' · ');
+    }
+
+    // GeoIP and GeoSite databases
+    const geoEl = document.getElementById('geo-status');
+    if (geoEl) {
+        const geo = data.geo || {};
+        const describe = (st) => {
+            if (!st) return '--';
+            if (st.error) return `加载失败：${st.error}`;
+            if (!st.loaded) return '未加载';
+            return `已加载（${new Date(st.modified * 1000).toLocaleDateString()}）`;
+        };
+        geoEl.textContent = `${describe(geo.geoip)} / ${describe(geo.geosite)}`;
     }
 
     // Goroutine count
@@ -129,43 +301,62 @@ function updateUI() {
         lastUpdateEl.textContent = new Date().toLocaleTimeString();
     }
 
-    // Down stats
+    // Health records
     const downStatsEl = document.getElementById('down-stats');
     if (downStatsEl) {
-        const downStats = data.down_stats || {};
-        let downStatsText = '';
-        if (Object.keys(downStats).length === 0) {
-            downStatsText = '未记录到服务器故障';
+        const records = data.health || [];
+        if (records.length === 0) {
+            downStatsEl.textContent = '暂无健康记录';
         } else {
-            for (const [server, count] of Object.entries(downStats)) {
-                downStatsText += `${server}: ${count}\n`;
-            }
+            downStatsEl.textContent = records.map(r => {
+                const parts = [`成功 ${r.successes}`, `失败 ${r.failures}`, `故障分 ${r.failure_score.toFixed(2)}`];
+                if (r.ttfb_ms > 0) {
+                    parts.push(`首字节 ${r.ttfb_ms.toFixed(0)}ms`, `${r.throughput_kbps.toFixed(0)}KB/s`);
+                }
+                const events = Object.entries(r.events || {}).map(([kind, n]) => `${kind} ${n}`);
+                if (events.length > 0) {
+                    parts.push(`naive 错误 ${events.join('/')}`);
+                }
+                return `${r.host}: ${parts.join(' · ')}`;
+            }).join('\n');
         }
-        downStatsEl.textContent = downStatsText;
     }
 
+    // Selector
+    const selectorSelect = document.getElementById('selector-select');
+    if (selectorSelect && document.activeElement !== selectorSelect && data.selector) {
+        selectorSelect.value = data.selector;
+    }
+
+    // Probe results
+    renderProbe(data.probe, data.nodes || []);
+
+    // Subscriptions
+    renderSubscriptions(data.subscriptions || []);
+
     // Available servers
     const serverSelect = document.getElementById('server-select');
     if (!serverSelect) return;
     const currentServer = data.current_server;
-    const servers = data.available_servers || [];
+    const nodes = data.nodes || [];
 
     // Preserve current selection
     const currentSelection = serverSelect.value;
 
     serverSelect.innerHTML = '<option value="">-- 选择要切换的服务器 --</option>';
-    servers.forEach(server => {
+    nodes.forEach(node => {
         const option = document.createElement('option');
-        option.value = server;
-        option.textContent = server + (server === currentServer ? ' (当前)' : '');
-        if (server === currentServer) {
+        option.value = node.url;
+        option.textContent = node.name + (node.url === currentServer ? ' (当前)' : '');
+        option.title = node.url;
+        if (node.url === currentServer) {
             option.disabled = true;
         }
         serverSelect.appendChild(option);
     });
 
     // Restore selection if still valid
-    if (currentSelection && servers.includes(currentSelection)) {
+    if (currentSelection && nodes.some(node => node.url === currentSelection)) {
         serverSelect.value = currentSelection;
     }
 
@@ -220,6 +411,28 @@ async function toggleAutoSwitch() {
     }
 }
 
+// Change selection strategy
+async function changeSelector() {
+    const strategy = document.getElementById('selector-select').value;
+
+    try {
+        const response = await fetch('/api/selector', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ strategy })
+        });
+
+        const result = await response.json();
+        if (result.success) {
+            fetchStatus();
+        } else {
+            alert('错误：' + (result.error || '未知错误'));
+        }
+    } catch (error) {
+        alert('切换选择策略时出错：' + error.message);
+    }
+}
+
 // Check for updates
 async function checkUpdates() {
     try {
@@ -286,7 +499,8 @@ async function switchToSelectedServer() {
     const selectedServer = select.value;
 
     if (!selectedServer) return;
-    if (!confirm('切换到：' + selectedServer + '？')) return;
+    const selectedName = select.options[select.selectedIndex].textContent;
+    if (!confirm('切换到：' + selectedName + '？')) return;
 
     try {
         const response = await fetch('/api/switch', {
@@ -324,6 +538,7 @@ document.addEventListener('DOMContentLoaded', function() {
 
     // Initialize
     fetchStatus();
+    fetchEvents();
 
     // Start countdown timer (update every second)
     countdownTimer = setInterval(updateCountdown, 1000);
@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime/debug"
+	"sync/atomic"
 )
 
 var (
@@ -13,9 +14,19 @@ var (
 	Naive    string
 )
 
-const (
-	UpstreamListenPort = "127.0.0.1:10790"
-)
+// upstreamAddr 当前接收新连接的 naive 进程的 SOCKS 地址，每次切换时更新
+var upstreamAddr atomic.Value
+
+// UpstreamAddr 返回当前 naive 进程的 SOCKS 地址，尚未启动时为空
+func UpstreamAddr() string {
+	addr, _ := upstreamAddr.Load().(string)
+	return addr
+}
+
+// SetUpstreamAddr 更新当前 naive 进程的 SOCKS 地址
+func SetUpstreamAddr(addr string) {
+	upstreamAddr.Store(addr)
+}
 
 func Init() {
 	ex, err := os.Executable()
@@ -0,0 +1,221 @@
+package geo
+
+import (
+	"context"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"naiveswitcher/internal/config"
+)
+
+// buildMMDB 生成只包含 IPv4 网段到国家代码的 MaxMind DB
+func buildMMDB(t *testing.T, countries map[string]string) []byte {
+	t.Helper()
+	type node [2]int // >0 子节点序号，0 为空，<0 为 -(数据偏移+1)
+	nodes := []node{{}}
+	var data []byte
+	for cidr, code := range countries {
+		p := netip.MustParsePrefix(cidr)
+		off := len(data)
+		data = append(data, mmdbMap(1, mmdbString("country"), mmdbMap(1, mmdbString("iso_code"), mmdbString(code)))...)
+		ip := p.Addr().As4()
+		cur := 0
+		for i := 0; i < p.Bits(); i++ {
+			bit := int(ip[i/8]>>(7-i%8)) & 1
+			if i == p.Bits()-1 {
+				nodes[cur][bit] = -(off + 1)
+				break
+			}
+			if nodes[cur][bit] <= 0 {
+				nodes = append(nodes, node{})
+				nodes[cur][bit] = len(nodes) - 1
+			}
+			cur = nodes[cur][bit]
+		}
+	}
+
+	n := len(nodes)
+	var buf []byte
+	for _, nd := range nodes {
+		for _, rec := range nd {
+			v := n
+			if rec > 0 {
+				v = rec
+			} else if rec < 0 {
+				v = n + 16 + (-rec - 1)
+			}
+			buf = append(buf, byte(v>>16), byte(v>>8), byte(v))
+		}
+	}
+	buf = append(buf, make([]byte, 16)...)
+	buf = append(buf, data...)
+	buf = append(buf, "\xAB\xCD\xEFMaxMind.com"...)
+	buf = append(buf, mmdbMap(3,
+		mmdbString("node_count"), mmdbUint(n),
+		mmdbString("record_size"), mmdbUint(24),
+		mmdbString("ip_version"), mmdbUint(4))...)
+	return buf
+}
+
+func mmdbString(s string) []byte {
+	return append([]byte{2<<5 | byte(len(s))}, s...)
+}
+
+func mmdbMap(pairs int, items ...[]byte) []byte {
+	b := []byte{7<<5 | byte(pairs)}
+	for _, item := range items {
+		b = append(b, item...)
+	}
+	return b
+}
+
+func mmdbUint(v int) []byte {
+	return []byte{6<<5 | 4, byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}
+}
+
+// geoSiteDomain 编码 v2ray geosite.dat 中的 Domain 消息
+type geoSiteDomain struct {
+	typ   uint64
+	value string
+	attrs []string
+}
+
+func buildGeoSite(sites map[string][]geoSiteDomain) []byte {
+	var list []byte
+	for code, domains := range sites {
+		site := pbBytes(nil, 1, []byte(code))
+		for _, d := range domains {
+			msg := binary.AppendUvarint([]byte{1 << 3}, d.typ)
+			msg = pbBytes(msg, 2, []byte(d.value))
+			for _, a := range d.attrs {
+				msg = pbBytes(msg, 3, pbBytes(nil, 1, []byte(a)))
+			}
+			site = pbBytes(site, 2, msg)
+		}
+		list = pbBytes(list, 1, site)
+	}
+	return list
+}
+
+func pbBytes(b []byte, num int, value []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(num<<3|2))
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+var testSites = map[string][]geoSiteDomain{
+	"CN": {
+		{typ: domainSuffix, value: "cn"},
+		{typ: domainFull, value: "www.example.com"},
+	},
+	"google": {
+		{typ: domainSuffix, value: "google.com"},
+		{typ: domainSuffix, value: "google.cn", attrs: []string{"cn"}},
+		{typ: domainPlain, value: "googleapis"},
+		{typ: domainRegex, value: `^gvt\d\.com$`},
+	},
+}
+
+func TestStoreLoad(t *testing.T) {
+	base := t.TempDir()
+	opts := config.Geo{GeoIPFile: "Country.mmdb", GeoSiteFile: filepath.Join(base, "sites", "geosite.dat")}
+	os.Mkdir(filepath.Join(base, "sites"), 0o755)
+	os.WriteFile(filepath.Join(base, opts.GeoIPFile), buildMMDB(t, map[string]string{"1.0.0.0/8": "CN", "8.8.0.0/16": "US"}), 0o644)
+	os.WriteFile(opts.GeoSiteFile, buildGeoSite(testSites), 0o644)
+
+	s := NewStore(base)
+	if !s.Load(opts) {
+		t.Fatal("first load should report changes")
+	}
+	if s.Load(opts) {
+		t.Fatal("unchanged files should not be reloaded")
+	}
+	for ip, want := range map[string]string{"1.2.3.4": "cn", "8.8.8.8": "us", "8.9.0.1": "", "::1": ""} {
+		if got := s.Country(netip.MustParseAddr(ip)); got != want {
+			t.Errorf("Country(%s) = %q, want %q", ip, got, want)
+		}
+	}
+	for _, c := range []struct {
+		category, domain string
+		want             bool
+	}{
+		{"cn", "www.gov.cn", true},
+		{"CN", "www.example.com", true},
+		{"cn", "example.com", false},
+		{"google", "maps.google.com", true},
+		{"google", "fonts.googleapis.com", true},
+		{"google", "gvt1.com", true},
+		{"google", "notgoogle.com", false},
+		{"google@cn", "www.google.cn", true},
+		{"google@cn", "www.google.com", false},
+		{"missing", "www.google.com", false},
+	} {
+		if got := s.InSite(c.category, c.domain); got != c.want {
+			t.Errorf("InSite(%s, %s) = %v, want %v", c.category, c.domain, got, c.want)
+		}
+	}
+
+	// 解析失败时保留已加载的数据库
+	os.WriteFile(opts.GeoSiteFile, []byte("garbage"), 0o644)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(opts.GeoSiteFile, future, future)
+	s.Load(opts)
+	if st := s.Status().GeoSite; !st.Loaded || st.Error == "" {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+	if !s.InSite("cn", "www.gov.cn") {
+		t.Fatal("previous geosite should be kept")
+	}
+
+	// 文件删除后清空
+	os.Remove(filepath.Join(base, opts.GeoIPFile))
+	s.Load(opts)
+	if st := s.Status().GeoIP; st.Loaded || s.Country(netip.MustParseAddr("1.2.3.4")) != "" {
+		t.Fatalf("geoip should be unloaded: %+v", st)
+	}
+}
+
+func TestStoreUpdate(t *testing.T) {
+	var requests atomic.Int32
+	body := buildGeoSite(testSites)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.URL.Path == "/bad.mmdb" {
+			w.Write([]byte("garbage"))
+			return
+		}
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	base := t.TempDir()
+	opts := config.Geo{
+		GeoIPFile:      "Country.mmdb",
+		GeoSiteFile:    "geosite.dat",
+		GeoIPURL:       srv.URL + "/bad.mmdb",
+		GeoSiteURL:     srv.URL + "/geosite.dat",
+		UpdateInterval: 24,
+	}
+	s := NewStore(base)
+	s.Update(context.Background(), opts)
+	if !s.InSite("cn", "www.gov.cn") || s.Status().GeoSite.Updated == 0 {
+		t.Fatalf("geosite should be downloaded and loaded: %+v", s.Status())
+	}
+	if _, err := os.Stat(filepath.Join(base, opts.GeoIPFile)); !os.IsNotExist(err) {
+		t.Fatal("invalid geoip should not be written")
+	}
+
+	// 未超过更新间隔的文件不再下载
+	requests.Store(0)
+	s.Update(context.Background(), opts)
+	if n := requests.Load(); n != 1 {
+		t.Fatalf("requests = %d, want only the missing geoip", n)
+	}
+}
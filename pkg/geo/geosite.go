@@ -0,0 +1,209 @@
+package geo
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// v2ray geosite.dat 中域名的类型
+const (
+	domainPlain  = 0 // 关键字
+	domainRegex  = 1 // 正则
+	domainSuffix = 2 // 域名及其子域名
+	domainFull   = 3 // 完整域名
+)
+
+// siteDB v2ray 格式的 geosite.dat，分类在首次使用时才解析
+type siteDB struct {
+	entries  map[string][]byte // 分类名（小写）到未解析的 GeoSite 消息
+	matchers map[string]*siteMatcher
+}
+
+// siteMatcher 一个分类（可带属性过滤）的域名匹配
+type siteMatcher struct {
+	full     map[string]bool
+	suffixes map[string]bool
+	keywords []string
+	regexes  []*regexp.Regexp
+}
+
+// parseGeoSite 解析 GeoSiteList，只建立分类索引
+// message GeoSiteList { repeated GeoSite entry = 1; }
+// message GeoSite { string country_code = 1; repeated Domain domain = 2; }
+func parseGeoSite(data []byte) (*siteDB, error) {
+	db := &siteDB{entries: make(map[string][]byte), matchers: make(map[string]*siteMatcher)}
+	err := eachField(data, func(num int, value []byte) error {
+		if num != 1 {
+			return nil
+		}
+		var code string
+		err := eachField(value, func(num int, v []byte) error {
+			if num == 1 {
+				code = strings.ToLower(string(v))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if code == "" {
+			return errors.New("geosite entry without country code")
+		}
+		db.entries[code] = value
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(db.entries) == 0 {
+		return nil, errors.New("no geosite entries")
+	}
+	return db, nil
+}
+
+// matcher 返回分类的匹配器，name 可以是 category@attr，只匹配带有该属性的域名
+// 调用方需要持有写锁，分类不存在时返回 nil
+func (db *siteDB) matcher(name string) *siteMatcher {
+	if m, ok := db.matchers[name]; ok {
+		return m
+	}
+	category, attr, _ := strings.Cut(name, "@")
+	entry, ok := db.entries[category]
+	if !ok {
+		db.matchers[name] = nil
+		return nil
+	}
+	m, err := buildMatcher(entry, attr)
+	if err != nil {
+		m = nil
+	}
+	db.matchers[name] = m
+	return m
+}
+
+// buildMatcher 解析分类中的域名
+// message Domain { Type type = 1; string value = 2; repeated Attribute attribute = 3; }
+// message Attribute { string key = 1; ... }
+func buildMatcher(entry []byte, attr string) (*siteMatcher, error) {
+	m := &siteMatcher{full: make(map[string]bool), suffixes: make(map[string]bool)}
+	return m, eachField(entry, func(num int, value []byte) error {
+		if num != 2 {
+			return nil
+		}
+		var typ uint64
+		var domain string
+		var attrs []string
+		err := eachField(value, func(num int, v []byte) error {
+			switch num {
+			case 1:
+				typ, _ = binary.Uvarint(v)
+			case 2:
+				domain = string(v)
+			case 3:
+				return eachField(v, func(num int, key []byte) error {
+					if num == 1 {
+						attrs = append(attrs, strings.ToLower(string(key)))
+					}
+					return nil
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if attr != "" && !slices.Contains(attrs, attr) {
+			return nil
+		}
+		switch typ {
+		case domainPlain:
+			m.keywords = append(m.keywords, strings.ToLower(domain))
+		case domainRegex:
+			re, err := regexp.Compile(domain)
+			if err != nil {
+				return fmt.Errorf("invalid regex %q: %w", domain, err)
+			}
+			m.regexes = append(m.regexes, re)
+		case domainSuffix:
+			m.suffixes[strings.ToLower(domain)] = true
+		case domainFull:
+			m.full[strings.ToLower(domain)] = true
+		}
+		return nil
+	})
+}
+
+// match 域名是否属于该分类，domain 需要已转为小写
+func (m *siteMatcher) match(domain string) bool {
+	if m.full[domain] {
+		return true
+	}
+	for d := domain; ; {
+		if m.suffixes[d] {
+			return true
+		}
+		i := strings.IndexByte(d, '.')
+		if i < 0 {
+			break
+		}
+		d = d[i+1:]
+	}
+	for _, k := range m.keywords {
+		if strings.Contains(domain, k) {
+			return true
+		}
+	}
+	for _, re := range m.regexes {
+		if re.MatchString(domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// eachField 遍历 protobuf 消息的字段，varint 字段传入编码后的字节
+func eachField(data []byte, f func(num int, value []byte) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errors.New("invalid field key")
+		}
+		data = data[n:]
+		num := int(key >> 3)
+		var value []byte
+		switch key & 7 {
+		case 0: // varint
+			_, n = binary.Uvarint(data)
+			if n <= 0 {
+				return errors.New("invalid varint")
+			}
+			value, data = data[:n], data[n:]
+		case 1: // 64 位
+			if len(data) < 8 {
+				return errors.New("truncated fixed64")
+			}
+			value, data = data[:8], data[8:]
+		case 2: // 长度前缀
+			l, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < l {
+				return errors.New("truncated field")
+			}
+			value, data = data[n:n+int(l)], data[n+int(l):]
+		case 5: // 32 位
+			if len(data) < 4 {
+				return errors.New("truncated fixed32")
+			}
+			value, data = data[:4], data[4:]
+		default:
+			return fmt.Errorf("unsupported wire type %d", key&7)
+		}
+		if err := f(num, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
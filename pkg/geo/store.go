@@ -0,0 +1,203 @@
+package geo
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+
+	"naiveswitcher/internal/config"
+	"naiveswitcher/pkg/log"
+)
+
+// FileStatus 数据库文件的加载状态
+type FileStatus struct {
+	Path     string `json:"path"`
+	Loaded   bool   `json:"loaded"`
+	Modified int64  `json:"modified,omitempty"` // 已加载文件的修改时间
+	Updated  int64  `json:"updated,omitempty"`  // 最近一次自动下载的时间
+	Error    string `json:"error,omitempty"`
+}
+
+// Status GeoIP 和 GeoSite 数据库的状态
+type Status struct {
+	GeoIP   FileStatus `json:"geoip"`
+	GeoSite FileStatus `json:"geosite"`
+}
+
+// Store 离线 GeoIP 和 GeoSite 数据库，文件变更后重新加载
+type Store struct {
+	mu       sync.RWMutex
+	basePath string
+	ip       *maxminddb.Reader
+	site     *siteDB
+	status   Status
+	ipMod    time.Time
+	siteMod  time.Time
+}
+
+// NewStore 创建数据库存储，相对路径位于 basePath 下
+func NewStore(basePath string) *Store {
+	return &Store{basePath: basePath}
+}
+
+// path 返回数据库文件的完整路径
+func (s *Store) path(name string) string {
+	if name == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(s.basePath, name)
+}
+
+// Load 加载路径或修改时间发生变化的数据库，文件不存在时清空对应的数据库
+// 解析失败时保留已加载的数据库，返回是否有数据库被重新加载
+func (s *Store) Load(opts config.Geo) bool {
+	ipPath, sitePath := s.path(opts.GeoIPFile), s.path(opts.GeoSiteFile)
+
+	s.mu.RLock()
+	ipStatus, siteStatus := s.status.GeoIP, s.status.GeoSite
+	ipMod, siteMod := s.ipMod, s.siteMod
+	s.mu.RUnlock()
+
+	changed := false
+	if mod, ok := fileChanged(ipPath, ipStatus.Path, ipMod); ok {
+		reader, err := loadGeoIP(ipPath)
+		s.mu.Lock()
+		s.ip, s.ipMod = apply(&s.status.GeoIP, ipPath, mod, reader, err, s.ip)
+		s.mu.Unlock()
+		changed = true
+	}
+	if mod, ok := fileChanged(sitePath, siteStatus.Path, siteMod); ok {
+		db, err := loadGeoSite(sitePath)
+		s.mu.Lock()
+		s.site, s.siteMod = apply(&s.status.GeoSite, sitePath, mod, db, err, s.site)
+		s.mu.Unlock()
+		changed = true
+	}
+	return changed
+}
+
+// apply 更新加载结果（需要外部已获取锁）
+// 文件不存在时清空数据库，解析失败时保留同一路径上已加载的数据库，直到文件再次变更
+func apply[T comparable](st *FileStatus, path string, mod time.Time, db T, err error, prev T) (T, time.Time) {
+	var zero T
+	samePath := st.Path == path
+	st.Path, st.Error = path, ""
+	switch {
+	case err == nil:
+		st.Loaded, st.Modified = true, mod.Unix()
+		log.DebugF("Loaded %s\n", path)
+		return db, mod
+	case os.IsNotExist(err):
+		st.Loaded, st.Modified = false, 0
+		return zero, mod
+	}
+	st.Error = err.Error()
+	log.Warn("geo_load", "load %s: %v", path, err)
+	if samePath && prev != zero {
+		return prev, mod
+	}
+	st.Loaded, st.Modified = false, 0
+	return zero, mod
+}
+
+// fileChanged 文件的路径或修改时间是否与已加载的不同
+func fileChanged(path, prevPath string, prevMod time.Time) (time.Time, bool) {
+	var mod time.Time
+	if info, err := os.Stat(path); err == nil {
+		mod = info.ModTime()
+	}
+	return mod, path != prevPath || !mod.Equal(prevMod)
+}
+
+func loadGeoIP(path string) (*maxminddb.Reader, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// 读入内存而不是 mmap，避免 Windows 上无法替换正在使用的文件
+	return maxminddb.FromBytes(data)
+}
+
+func loadGeoSite(path string) (*siteDB, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseGeoSite(data)
+}
+
+// Country 返回 IP 所属国家的代码（小写），未加载数据库或未找到时为空
+func (s *Store) Country(addr netip.Addr) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.ip == nil {
+		return ""
+	}
+	var record struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+		RegisteredCountry struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"registered_country"`
+	}
+	if err := s.ip.Lookup(net.IP(addr.AsSlice()), &record); err != nil {
+		return ""
+	}
+	if record.Country.ISOCode != "" {
+		return strings.ToLower(record.Country.ISOCode)
+	}
+	return strings.ToLower(record.RegisteredCountry.ISOCode)
+}
+
+// InSite 域名是否属于 geosite 分类，category 可以是 category@attr
+func (s *Store) InSite(category string, domain string) bool {
+	category = strings.ToLower(category)
+	s.mu.RLock()
+	site := s.site
+	m, cached := (*siteMatcher)(nil), false
+	if site != nil {
+		m, cached = site.matchers[category]
+	}
+	s.mu.RUnlock()
+	if site == nil {
+		return false
+	}
+	if !cached {
+		s.mu.Lock()
+		m = site.matcher(category)
+		s.mu.Unlock()
+	}
+	return m != nil && m.match(strings.ToLower(domain))
+}
+
+// Status 返回数据库的加载状态
+func (s *Store) Status() Status {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.status
+}
+
+// watchInterval 数据库文件变更检查间隔
+const watchInterval = 10 * time.Second
+
+// Watch 定期检查数据库文件，变更后重新加载
+func Watch(ctx context.Context, s *Store, cfg *config.Config) {
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Load(cfg.Current().Geo)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package geo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+
+	"naiveswitcher/internal/config"
+	"naiveswitcher/pkg/log"
+	"naiveswitcher/util"
+)
+
+// maxDownloadSize 数据库文件的最大下载大小
+const maxDownloadSize = 64 << 20
+
+// Update 下载超过更新间隔的数据库，校验通过后替换文件并重新加载
+func (s *Store) Update(ctx context.Context, opts config.Geo) {
+	if opts.UpdateInterval <= 0 {
+		return
+	}
+	interval := time.Duration(opts.UpdateInterval) * time.Hour
+	updated := false
+	if s.download(ctx, opts.GeoIPURL, s.path(opts.GeoIPFile), interval, func(data []byte) error {
+		_, err := maxminddb.FromBytes(data)
+		return err
+	}) {
+		s.mu.Lock()
+		s.status.GeoIP.Updated = time.Now().Unix()
+		s.mu.Unlock()
+		updated = true
+	}
+	if s.download(ctx, opts.GeoSiteURL, s.path(opts.GeoSiteFile), interval, func(data []byte) error {
+		_, err := parseGeoSite(data)
+		return err
+	}) {
+		s.mu.Lock()
+		s.status.GeoSite.Updated = time.Now().Unix()
+		s.mu.Unlock()
+		updated = true
+	}
+	if updated {
+		s.Load(opts)
+	}
+}
+
+// download 文件不存在或早于 interval 时下载，返回是否替换了文件
+func (s *Store) download(ctx context.Context, url string, path string, interval time.Duration, validate func([]byte) error) bool {
+	if url == "" || path == "" {
+		return false
+	}
+	if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) < interval {
+		return false
+	}
+	log.DebugF("Downloading %s\n", url)
+	data, err := fetch(ctx, url)
+	if err == nil {
+		err = validate(data)
+	}
+	if err == nil {
+		err = util.WriteFileAtomic(path, data, 0o644)
+	}
+	if err != nil {
+		log.Warn("geo_update", "update %s from %s: %v", path, url, err)
+		return false
+	}
+	log.DebugF("Updated %s (%d bytes)\n", path, len(data))
+	return true
+}
+
+func fetch(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxDownloadSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxDownloadSize {
+		return nil, fmt.Errorf("file larger than %d bytes", maxDownloadSize)
+	}
+	return data, nil
+}
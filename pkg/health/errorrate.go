@@ -0,0 +1,107 @@
+package health
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bucket 一秒内的连接统计
+type bucket struct {
+	sec      int64
+	total    int
+	failures int
+}
+
+// WindowStats 服务器在滑动窗口内的连接统计
+type WindowStats struct {
+	Server   string  `json:"server"`
+	Total    int     `json:"total"`
+	Failures int     `json:"failures"`
+	Rate     float64 `json:"rate"` // 失败率（百分比）
+}
+
+// ErrorRate 按服务器统计滑动时间窗口内用户连接的失败率
+type ErrorRate struct {
+	mu      sync.Mutex
+	servers map[string][]bucket
+}
+
+// NewErrorRate 创建失败率统计
+func NewErrorRate() *ErrorRate {
+	return &ErrorRate{servers: make(map[string][]bucket)}
+}
+
+// Record 记录一次经由 server 的连接结果，返回窗口内的统计
+func (e *ErrorRate) Record(server string, failed bool, window time.Duration) WindowStats {
+	return e.record(server, failed, time.Now(), window)
+}
+
+func (e *ErrorRate) record(server string, failed bool, now time.Time, window time.Duration) WindowStats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	buckets := prune(e.servers[server], now, window)
+	sec := now.Unix()
+	if n := len(buckets); n == 0 || buckets[n-1].sec != sec {
+		buckets = append(buckets, bucket{sec: sec})
+	}
+	b := &buckets[len(buckets)-1]
+	b.total++
+	if failed {
+		b.failures++
+	}
+	e.servers[server] = buckets
+	return stats(server, buckets)
+}
+
+// Reset 清除服务器的统计，切换后重新计算
+func (e *ErrorRate) Reset(server string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.servers, server)
+}
+
+// Snapshot 返回按服务器排序的窗口内统计，删除窗口内没有连接的服务器
+func (e *ErrorRate) Snapshot(window time.Duration) []WindowStats {
+	return e.snapshot(time.Now(), window)
+}
+
+func (e *ErrorRate) snapshot(now time.Time, window time.Duration) []WindowStats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	result := make([]WindowStats, 0, len(e.servers))
+	for server, buckets := range e.servers {
+		buckets = prune(buckets, now, window)
+		if len(buckets) == 0 {
+			delete(e.servers, server)
+			continue
+		}
+		e.servers[server] = buckets
+		result = append(result, stats(server, buckets))
+	}
+	slices.SortFunc(result, func(a, b WindowStats) int { return strings.Compare(a.Server, b.Server) })
+	return result
+}
+
+// prune 删除窗口之外的统计
+func prune(buckets []bucket, now time.Time, window time.Duration) []bucket {
+	cutoff := now.Add(-window).Unix()
+	i := 0
+	for i < len(buckets) && buckets[i].sec <= cutoff {
+		i++
+	}
+	return buckets[i:]
+}
+
+func stats(server string, buckets []bucket) WindowStats {
+	s := WindowStats{Server: server}
+	for _, b := range buckets {
+		s.Total += b.total
+		s.Failures += b.failures
+	}
+	if s.Total > 0 {
+		s.Rate = float64(s.Failures) * 100 / float64(s.Total)
+	}
+	return s
+}
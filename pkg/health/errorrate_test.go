@@ -0,0 +1,35 @@
+package health
+
+import (
+	"testing"
+	"time"
+)
+
+func TestErrorRateWindow(t *testing.T) {
+	e := NewErrorRate()
+	window := time.Minute
+	start := time.Unix(1_700_000_000, 0)
+
+	for i := range 10 {
+		e.record("a", i < 8, start, window)
+	}
+	s := e.record("a", false, start.Add(30*time.Second), window)
+	if s.Total != 11 || s.Failures != 8 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+
+	// 窗口外的失败不再计入
+	s = e.record("a", false, start.Add(61*time.Second), window)
+	if s.Total != 2 || s.Failures != 0 || s.Rate != 0 {
+		t.Fatalf("unexpected stats after window: %+v", s)
+	}
+
+	e.record("b", true, start.Add(61*time.Second), window)
+	snap := e.snapshot(start.Add(100*time.Second), window)
+	if len(snap) != 2 || snap[0].Server != "a" || snap[0].Total != 1 || snap[1].Rate != 100 {
+		t.Fatalf("unexpected snapshot: %+v", snap)
+	}
+	if snap = e.snapshot(start.Add(200*time.Second), window); len(snap) != 0 {
+		t.Fatalf("expected empty snapshot, got %+v", snap)
+	}
+}
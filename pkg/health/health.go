@@ -0,0 +1,206 @@
+package health
+
+import (
+	"encoding/json"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"naiveswitcher/pkg/subscription"
+	"naiveswitcher/util"
+)
+
+const healthFile = "health.json"
+
+// ewmaAlpha 新测量值在 EWMA 中的权重
+const ewmaAlpha = 0.3
+
+// naive 输出中识别出的服务器错误类型
+const (
+	EventProxyAuth         = "proxy_auth"         // 407，代理认证失败
+	EventCertificate       = "certificate"        // 服务器证书错误
+	EventConnectionRefused = "connection_refused" // 服务器拒绝连接
+)
+
+// Record 单个服务器（按主机名）的历史健康记录
+type Record struct {
+	Host         string         `json:"host"`
+	Successes    int            `json:"successes"`
+	Failures     int            `json:"failures"`
+	FailureScore float64        `json:"failure_score"`   // 随时间衰减的故障分，参与选择
+	TTFBMs       float64        `json:"ttfb_ms"`         // 首字节时间的 EWMA
+	Throughput   float64        `json:"throughput_kbps"` // 下载速度的 EWMA
+	LastSeenGood time.Time      `json:"last_seen_good"`
+	LastFailure  time.Time      `json:"last_failure"`
+	ScoreDecayed time.Time      `json:"score_decayed"`    // FailureScore 上一次衰减的时间
+	Events       map[string]int `json:"events,omitempty"` // 按类型统计的 naive 错误事件
+}
+
+// decay 按半衰期衰减故障分（需要外部已获取锁）
+func (r *Record) decay(now time.Time, halfLife time.Duration) {
+	if halfLife > 0 && !r.ScoreDecayed.IsZero() && now.After(r.ScoreDecayed) {
+		r.FailureScore *= math.Pow(0.5, float64(now.Sub(r.ScoreDecayed))/float64(halfLife))
+		if r.FailureScore < 0.01 {
+			r.FailureScore = 0
+		}
+	}
+	r.ScoreDecayed = now
+}
+
+// Store 服务器健康记录，持久化到 basePath 下
+type Store struct {
+	mu       sync.RWMutex
+	records  map[string]*Record
+	basePath string
+}
+
+// NewStore 创建健康记录存储并加载磁盘上的记录
+func NewStore(basePath string) (*Store, error) {
+	s := &Store{records: make(map[string]*Record), basePath: basePath}
+	data, err := os.ReadFile(s.path())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return s, err
+	}
+	var records []*Record
+	if err := json.Unmarshal(data, &records); err != nil {
+		return s, err
+	}
+	for _, r := range records {
+		s.records[r.Host] = r
+	}
+	return s, nil
+}
+
+func (s *Store) path() string {
+	return filepath.Join(s.basePath, healthFile)
+}
+
+// record 返回主机的记录，不存在时创建（需要外部已获取锁）
+func (s *Store) record(host string) *Record {
+	r, ok := s.records[host]
+	if !ok {
+		r = &Record{Host: host}
+		s.records[host] = r
+	}
+	return r
+}
+
+// RecordProbe 记录一轮测速结果并持久化
+func (s *Store) RecordProbe(results []subscription.ProbeResult, halfLife time.Duration) error {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, res := range results {
+		if res.Host == "" {
+			continue
+		}
+		r := s.record(res.Host)
+		if !res.OK {
+			s.failUnsafe(r, now, halfLife)
+			continue
+		}
+		r.Successes++
+		r.LastSeenGood = now
+		r.TTFBMs = ewma(r.TTFBMs, res.TTFBMs)
+		r.Throughput = ewma(r.Throughput, res.Throughput)
+	}
+	return s.saveUnsafe()
+}
+
+// RecordFailure 记录一次服务器故障（如连接出错被切走）并持久化
+func (s *Store) RecordFailure(host string, halfLife time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failUnsafe(s.record(host), time.Now(), halfLife)
+	return s.saveUnsafe()
+}
+
+// RecordEvent 记录一次 naive 报告的服务器错误，计为一次故障并持久化
+func (s *Store) RecordEvent(host string, kind string, halfLife time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.record(host)
+	if r.Events == nil {
+		r.Events = make(map[string]int)
+	}
+	r.Events[kind]++
+	s.failUnsafe(r, time.Now(), halfLife)
+	return s.saveUnsafe()
+}
+
+func (s *Store) failUnsafe(r *Record, now time.Time, halfLife time.Duration) {
+	r.decay(now, halfLife)
+	r.Failures++
+	r.FailureScore++
+	r.LastFailure = now
+}
+
+// Penalty 返回各主机衰减后的故障分，用于测速评分
+func (s *Store) Penalty(halfLife time.Duration) map[string]float64 {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	penalty := make(map[string]float64, len(s.records))
+	for host, r := range s.records {
+		r.decay(now, halfLife)
+		if r.FailureScore > 0 {
+			penalty[host] = r.FailureScore
+		}
+	}
+	return penalty
+}
+
+// Snapshot 返回按主机名排序的所有记录，故障分已衰减到当前时间
+func (s *Store) Snapshot(halfLife time.Duration) []Record {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records := make([]Record, 0, len(s.records))
+	for _, r := range s.records {
+		r.decay(now, halfLife)
+		rec := *r
+		rec.Events = maps.Clone(r.Events)
+		records = append(records, rec)
+	}
+	slices.SortFunc(records, func(a, b Record) int { return strings.Compare(a.Host, b.Host) })
+	return records
+}
+
+// Prune 删除不在 hosts 中且故障分已衰减为 0 的记录
+func (s *Store) Prune(hosts map[string]bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for host, r := range s.records {
+		if !hosts[host] && r.FailureScore == 0 {
+			delete(s.records, host)
+		}
+	}
+}
+
+func (s *Store) saveUnsafe() error {
+	records := make([]*Record, 0, len(s.records))
+	for _, r := range s.records {
+		records = append(records, r)
+	}
+	slices.SortFunc(records, func(a, b *Record) int { return strings.Compare(a.Host, b.Host) })
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(s.path(), data, 0o600)
+}
+
+func ewma(prev, sample float64) float64 {
+	if prev == 0 {
+		return sample
+	}
+	return ewmaAlpha*sample + (1-ewmaAlpha)*prev
+}
@@ -0,0 +1,82 @@
+package health
+
+import (
+	"testing"
+	"time"
+
+	"naiveswitcher/pkg/subscription"
+)
+
+func TestStoreRecordsAndPersists(t *testing.T) {
+	base := t.TempDir()
+	s, err := NewStore(base)
+	if err != nil {
+		t.Fatalf("NewStore error: %v", err)
+	}
+	probes := []subscription.ProbeResult{
+		{Host: "a.example.com", OK: true, TTFBMs: 100, Throughput: 1000},
+		{Host: "b.example.com"},
+	}
+	if err := s.RecordProbe(probes, time.Hour); err != nil {
+		t.Fatalf("RecordProbe error: %v", err)
+	}
+	probes[0].TTFBMs = 200
+	if err := s.RecordProbe(probes[:1], time.Hour); err != nil {
+		t.Fatalf("RecordProbe error: %v", err)
+	}
+
+	loaded, err := NewStore(base)
+	if err != nil {
+		t.Fatalf("NewStore error: %v", err)
+	}
+	records := loaded.Snapshot(time.Hour)
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %+v", records)
+	}
+	a, b := records[0], records[1]
+	if a.Successes != 2 || a.TTFBMs != 130 || a.LastSeenGood.IsZero() {
+		t.Fatalf("unexpected record for a: %+v", a)
+	}
+	if b.Failures != 1 || b.FailureScore <= 0.99 || b.LastFailure.IsZero() {
+		t.Fatalf("unexpected record for b: %+v", b)
+	}
+}
+
+func TestFailureScoreDecays(t *testing.T) {
+	r := &Record{Host: "a.example.com", FailureScore: 4, ScoreDecayed: time.Now().Add(-2 * time.Hour)}
+	r.decay(time.Now(), time.Hour)
+	if r.FailureScore < 0.99 || r.FailureScore > 1.01 {
+		t.Fatalf("expected score to halve twice, got %f", r.FailureScore)
+	}
+
+	s := &Store{records: map[string]*Record{"a.example.com": r}}
+	s.Prune(map[string]bool{})
+	if len(s.records) != 1 {
+		t.Fatal("records with remaining failure score must be kept")
+	}
+	r.FailureScore = 0
+	s.Prune(map[string]bool{})
+	if len(s.records) != 0 {
+		t.Fatal("expected record for removed host to be pruned")
+	}
+}
+
+func TestStoreRecordEvent(t *testing.T) {
+	s, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewStore error: %v", err)
+	}
+	for range 2 {
+		if err := s.RecordEvent("a.example.com", EventProxyAuth, time.Hour); err != nil {
+			t.Fatalf("RecordEvent error: %v", err)
+		}
+	}
+	records := s.Snapshot(time.Hour)
+	if len(records) != 1 || records[0].Failures != 2 || records[0].Events[EventProxyAuth] != 2 {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+	records[0].Events[EventProxyAuth] = 0
+	if s.Snapshot(time.Hour)[0].Events[EventProxyAuth] != 2 {
+		t.Fatal("snapshot shares events map with store")
+	}
+}
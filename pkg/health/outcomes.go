@@ -0,0 +1,40 @@
+package health
+
+import (
+	"maps"
+	"sync"
+)
+
+// Outcomes 按服务器和分类累计的用户连接结果
+type Outcomes struct {
+	mu     sync.Mutex
+	counts map[string]map[string]int
+}
+
+// NewOutcomes 创建连接结果计数
+func NewOutcomes() *Outcomes {
+	return &Outcomes{counts: make(map[string]map[string]int)}
+}
+
+// Add 记录一次经由 server 的连接结果
+func (o *Outcomes) Add(server string, outcome string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	counts, ok := o.counts[server]
+	if !ok {
+		counts = make(map[string]int)
+		o.counts[server] = counts
+	}
+	counts[outcome]++
+}
+
+// Snapshot 返回各服务器按分类的计数
+func (o *Outcomes) Snapshot() map[string]map[string]int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	result := make(map[string]map[string]int, len(o.counts))
+	for server, counts := range o.counts {
+		result[server] = maps.Clone(counts)
+	}
+	return result
+}
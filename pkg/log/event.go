@@ -0,0 +1,52 @@
+package log
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Event 需要用户关注的事件，在 Web 控制台展示
+type Event struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Kind    string    `json:"kind"`
+	Message string    `json:"message"`
+}
+
+var (
+	eventMutex = new(sync.RWMutex)
+	eventIndex = 0
+	eventCount = 0
+	eventCache [200]Event
+)
+
+// Warn 记录警告事件，同时写入调试日志
+func Warn(kind string, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	DebugF("Warning [%s]: %s\n", kind, msg)
+
+	eventMutex.Lock()
+	defer eventMutex.Unlock()
+	eventCache[eventIndex] = Event{
+		Time:    time.Now(),
+		Level:   "warn",
+		Kind:    kind,
+		Message: msg,
+	}
+	eventIndex = (eventIndex + 1) % len(eventCache)
+	if eventCount < len(eventCache) {
+		eventCount++
+	}
+}
+
+// Events 按时间倒序返回最近的事件
+func Events() []Event {
+	eventMutex.RLock()
+	defer eventMutex.RUnlock()
+	events := make([]Event, 0, eventCount)
+	for i := 1; i <= eventCount; i++ {
+		events = append(events, eventCache[(eventIndex-i+len(eventCache))%len(eventCache)])
+	}
+	return events
+}
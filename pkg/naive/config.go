@@ -0,0 +1,61 @@
+package naive
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"naiveswitcher/internal/config"
+	"naiveswitcher/internal/types"
+	"naiveswitcher/pkg/common"
+	"naiveswitcher/util"
+)
+
+// RenderConfig 根据代理、本地监听地址和选项生成 naive 的 config.json 内容
+func RenderConfig(proxy string, listen string, opts config.NaiveOptions) types.NaiveConfig {
+	c := types.NaiveConfig{
+		Listen:              "socks://" + listen,
+		Proxy:               proxy,
+		InsecureConcurrency: opts.InsecureConcurrency,
+		HostResolverRules:   opts.HostResolverRules,
+		Log:                 opts.Log,
+		NoPostQuantum:       opts.NoPostQuantum,
+	}
+	if len(opts.ExtraHeaders) > 0 {
+		names := make([]string, 0, len(opts.ExtraHeaders))
+		for name := range opts.ExtraHeaders {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		headers := make([]string, 0, len(names))
+		for _, name := range names {
+			headers = append(headers, name+": "+opts.ExtraHeaders[name])
+		}
+		c.ExtraHeaders = strings.Join(headers, "\r\n")
+	}
+	return c
+}
+
+// ConfigPath 返回监听在 listen 上的 naive 进程使用的配置文件路径，每个进程独立
+func ConfigPath(listen string) string {
+	_, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		port = "default"
+	}
+	return filepath.Join(common.BasePath, "naive-"+port+".json")
+}
+
+// writeConfig 写入配置文件，包含凭据，仅当前用户可读
+func writeConfig(path string, c types.NaiveConfig) error {
+	if c.Proxy == "" {
+		return errors.New("no proxy found")
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(path, data, 0o600)
+}
@@ -3,11 +3,14 @@ package naive
 import (
 	"context"
 	"errors"
+	"fmt"
+	"net"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
 	"slices"
+	"strconv"
 	"strings"
 
 	"golang.org/x/mod/semver"
@@ -25,16 +28,17 @@ func Init() error {
 }
 
 // naive version: naiveproxy-v130.0.6723.40-5-mac-x64
-func NaiveCmd(state *types.GlobalState, proxy string) (*exec.Cmd, context.CancelFunc, error) {
-	if common.Naive == "" {
+// NaiveCmd 将配置写入 configPath 并创建使用 binary 和该配置文件的 naive 命令
+func NaiveCmd(state *types.GlobalState, binary string, c types.NaiveConfig, configPath string) (*exec.Cmd, context.CancelFunc, error) {
+	if binary == "" {
 		return nil, nil, errors.New("no naive found")
 	}
-	if proxy == "" {
-		return nil, nil, errors.New("no proxy found")
+	if err := writeConfig(configPath, c); err != nil {
+		return nil, nil, err
 	}
 	// 创建一个可取消的子context
 	ctx, cancel := context.WithCancel(state.AppContext)
-	cmd := exec.CommandContext(ctx, common.BasePath+"/"+common.Naive, "--listen=socks://"+common.UpstreamListenPort, "--proxy="+proxy)
+	cmd := exec.CommandContext(ctx, common.BasePath+"/"+binary, configPath)
 
 	// 设置进程组，确保可以杀死整个进程树
 	cmd.SysProcAttr = getSysProcAttr()
@@ -42,6 +46,25 @@ func NaiveCmd(state *types.GlobalState, proxy string) (*exec.Cmd, context.Cancel
 	return cmd, cancel, nil
 }
 
+// FreeAddr 在 host 上分配一个空闲的本地端口，lo 为 0 时由系统分配，否则在 [lo, hi] 中选择第一个空闲端口
+func FreeAddr(host string, lo int, hi int) (string, error) {
+	if lo == 0 {
+		lo, hi = 0, 0
+	}
+	var lastErr error
+	for port := lo; port <= hi; port++ {
+		l, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		addr := l.Addr().String()
+		l.Close()
+		return addr, nil
+	}
+	return "", fmt.Errorf("no free port in %d-%d: %w", lo, hi, lastErr)
+}
+
 func getNaiveList() []string {
 	var naiveList []string
 	err := filepath.Walk(common.BasePath, func(p string, info os.FileInfo, err error) error {
@@ -0,0 +1,34 @@
+package naive
+
+import (
+	"net"
+	"strconv"
+	"testing"
+)
+
+func TestFreeAddrRange(t *testing.T) {
+	addr, err := FreeAddr("127.0.0.1", 0, 0)
+	if err != nil {
+		t.Fatalf("FreeAddr error: %v", err)
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("listen %s: %v", addr, err)
+	}
+	defer l.Close()
+
+	// 范围内唯一的端口已被占用
+	port := l.Addr().(*net.TCPAddr).Port
+	if _, err := FreeAddr("127.0.0.1", port, port); err == nil {
+		t.Fatal("expected error when the only port is in use")
+	}
+	if port < 65535 {
+		got, err := FreeAddr("127.0.0.1", port, port+1)
+		if err != nil {
+			t.Skipf("next port also in use: %v", err)
+		}
+		if got != net.JoinHostPort("127.0.0.1", strconv.Itoa(port+1)) {
+			t.Fatalf("FreeAddr = %s, want next port", got)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package naive
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+
+	"naiveswitcher/pkg/health"
+)
+
+// maxLineLength 单行最长缓冲的字节数，超出时按一行输出
+const maxLineLength = 64 * 1024
+
+// LineWriter 按行缓冲 naive 的输出，每个完整的行回调一次
+type LineWriter struct {
+	mu     sync.Mutex
+	buf    []byte
+	onLine func(line string)
+}
+
+// NewLineWriter 创建按行回调的 Writer
+func NewLineWriter(onLine func(line string)) *LineWriter {
+	return &LineWriter{onLine: onLine}
+}
+
+func (w *LineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.emit(w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+	if len(w.buf) >= maxLineLength {
+		w.emit(w.buf)
+		w.buf = nil
+	}
+	return len(p), nil
+}
+
+// Flush 输出缓冲中没有换行结尾的最后一行
+func (w *LineWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.emit(w.buf)
+	w.buf = nil
+}
+
+// emit 回调一行，忽略空行（需要外部已获取锁）
+func (w *LineWriter) emit(line []byte) {
+	s := strings.TrimRight(string(line), "\r")
+	if strings.TrimSpace(s) == "" {
+		return
+	}
+	w.onLine(s)
+}
+
+// outputPatterns naive 输出中可以归因到服务器的错误
+var outputPatterns = []struct {
+	kind     string
+	patterns []string
+}{
+	{health.EventProxyAuth, []string{"ERR_PROXY_AUTH", "407 Proxy Authentication Required", "Proxy-Authenticate"}},
+	{health.EventCertificate, []string{"ERR_CERT_", "ERR_SSL_", "certificate verify failed", "CERTIFICATE_VERIFY_FAILED"}},
+	{health.EventConnectionRefused, []string{"ERR_CONNECTION_REFUSED", "Connection refused"}},
+}
+
+// Classify 识别 naive 输出中的服务器错误，返回健康事件类型，无法识别时返回空
+func Classify(line string) string {
+	for _, p := range outputPatterns {
+		for _, pattern := range p.patterns {
+			if strings.Contains(line, pattern) {
+				return p.kind
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,38 @@
+package naive
+
+import (
+	"slices"
+	"testing"
+
+	"naiveswitcher/pkg/health"
+)
+
+func TestLineWriter(t *testing.T) {
+	var lines []string
+	w := NewLineWriter(func(line string) { lines = append(lines, line) })
+	w.Write([]byte("first li"))
+	w.Write([]byte("ne\r\n\nsecond\nthi"))
+	w.Write([]byte("rd"))
+	if want := []string{"first line", "second"}; !slices.Equal(lines, want) {
+		t.Fatalf("lines before flush = %q, want %q", lines, want)
+	}
+	w.Flush()
+	if want := []string{"first line", "second", "third"}; !slices.Equal(lines, want) {
+		t.Fatalf("lines after flush = %q, want %q", lines, want)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	cases := map[string]string{
+		"[1016/120000.000:ERROR:naive_proxy.cc(123)] Connection error: net::ERR_PROXY_AUTH_UNSUPPORTED":                              health.EventProxyAuth,
+		"HTTP/1.1 407 Proxy Authentication Required":                                                                                 health.EventProxyAuth,
+		"ssl_client_socket_impl.cc(985)] handshake failed; returned -1, SSL error code 1, net_error -202 ERR_CERT_AUTHORITY_INVALID": health.EventCertificate,
+		"net::ERR_CONNECTION_REFUSED":         health.EventConnectionRefused,
+		"Listening on socks://127.0.0.1:1080": "",
+	}
+	for line, want := range cases {
+		if got := Classify(line); got != want {
+			t.Errorf("Classify(%q) = %q, want %q", line, got, want)
+		}
+	}
+}
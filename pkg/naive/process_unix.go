@@ -3,49 +3,40 @@
 package naive
 
 import (
+	"os/exec"
 	"syscall"
 	"time"
 
-	"naiveswitcher/internal/types"
 	"naiveswitcher/pkg/log"
 )
 
-// KillProcessGroup 终止整个进程组
-func KillProcessGroup(state *types.GlobalState, pid int) {
+// KillProcessGroup 终止整个进程组，done 在进程退出（Wait 返回）后关闭
+func KillProcessGroup(cmd *exec.Cmd, done <-chan struct{}) {
+	pid := cmd.Process.Pid
 	pgid := pid // 进程组ID默认等于进程ID（因为我们设置了Setpgid）
 
 	// 先尝试发送 SIGTERM 到整个进程组，给进程优雅退出的机会
 	if err := syscall.Kill(-pgid, syscall.SIGTERM); err != nil {
 		log.DebugF("Error sending SIGTERM to process group (PGID: %d): %v, trying single process\n", pgid, err)
 		// 如果进程组信号失败，尝试只发送给主进程
-		if err := state.NaiveCmd.Process.Signal(syscall.SIGTERM); err != nil {
+		if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
 			log.DebugF("Error sending SIGTERM to naive process (PID: %d): %v\n", pid, err)
 		}
 	} else {
 		log.DebugF("Sent SIGTERM to process group (PGID: %d)\n", pgid)
 	}
 
-	// 等待进程退出，带超时机制
-	done := make(chan error, 1)
-	go func() {
-		done <- state.NaiveCmd.Wait()
-	}()
-
-	// 等待最多2秒
+	// 等待进程退出，最多2秒
 	select {
-	case err := <-done:
-		if err != nil {
-			log.DebugF("Naive process (PID: %d) exited with error: %v\n", pid, err)
-		} else {
-			log.DebugF("Naive process (PID: %d) exited gracefully\n", pid)
-		}
+	case <-done:
+		log.DebugF("Naive process (PID: %d) exited\n", pid)
 	case <-time.After(2 * time.Second):
 		// 超时后强制杀死整个进程组
 		log.DebugF("Naive process (PID: %d) did not exit after SIGTERM, sending SIGKILL to process group\n", pid)
 		if err := syscall.Kill(-pgid, syscall.SIGKILL); err != nil {
 			log.DebugF("Error sending SIGKILL to process group (PGID: %d): %v, trying single process\n", pgid, err)
 			// 如果进程组信号失败，尝试只杀死主进程
-			if err := state.NaiveCmd.Process.Kill(); err != nil {
+			if err := cmd.Process.Kill(); err != nil {
 				log.DebugF("Error killing naive process (PID: %d): %v\n", pid, err)
 			}
 		}
@@ -0,0 +1,115 @@
+package naive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// readyAttemptTimeout 单次就绪检查的超时
+const readyAttemptTimeout = 5 * time.Second
+
+// WaitReady 等待 naive 的本地 SOCKS 端口可用
+// 端口完成 SOCKS5 握手后，target 非空时再经由该端口 CONNECT target，均成功才视为就绪
+func WaitReady(ctx context.Context, addr string, target string) error {
+	var lastErr error
+	for {
+		attemptCtx, cancel := context.WithTimeout(ctx, readyAttemptTimeout)
+		lastErr = checkReady(attemptCtx, addr, target)
+		cancel()
+		if lastErr == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("naive on %s not ready: %w", addr, lastErr)
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+func checkReady(ctx context.Context, addr string, target string) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if err := socksHandshake(conn); err != nil {
+		return err
+	}
+	if target == "" {
+		return nil
+	}
+	return socksConnect(conn, target)
+}
+
+// socksHandshake 发送无认证的 SOCKS5 问候
+func socksHandshake(rw io.ReadWriter) error {
+	if _, err := rw.Write([]byte{5, 1, 0}); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(rw, reply); err != nil {
+		return fmt.Errorf("socks handshake: %w", err)
+	}
+	if reply[0] != 5 || reply[1] != 0 {
+		return fmt.Errorf("socks handshake rejected: %v", reply)
+	}
+	return nil
+}
+
+// socksConnect 发送 CONNECT 请求并读取完整的应答
+func socksConnect(rw io.ReadWriter, target string) error {
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return err
+	}
+	if len(host) > 255 {
+		return errors.New("target host too long")
+	}
+
+	req := []byte{5, 1, 0, 3, byte(len(host))}
+	req = append(req, host...)
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := rw.Write(req); err != nil {
+		return err
+	}
+
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(rw, head); err != nil {
+		return fmt.Errorf("socks connect: %w", err)
+	}
+	if head[1] != 0 {
+		return fmt.Errorf("socks connect to %s failed, reply code %d", target, head[1])
+	}
+	var addrLen int
+	switch head[3] {
+	case 1:
+		addrLen = net.IPv4len
+	case 4:
+		addrLen = net.IPv6len
+	case 3:
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(rw, l); err != nil {
+			return err
+		}
+		addrLen = int(l[0])
+	default:
+		return fmt.Errorf("socks connect: unknown address type %d", head[3])
+	}
+	_, err = io.ReadFull(rw, make([]byte, addrLen+2))
+	return err
+}
@@ -0,0 +1,30 @@
+package naive
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"naiveswitcher/internal/sockstest"
+)
+
+func TestWaitReady(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := WaitReady(ctx, sockstest.Serve(t, sockstest.Reply(0)), "example.com:443"); err != nil {
+		t.Fatalf("expected ready, got %v", err)
+	}
+}
+
+func TestWaitReadyConnectFails(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	addr := sockstest.Serve(t, sockstest.Reply(4))
+	if err := WaitReady(ctx, addr, "example.com:443"); err == nil {
+		t.Fatal("expected error when CONNECT is refused")
+	}
+	// 只检查握手时视为就绪
+	if err := WaitReady(context.Background(), addr, ""); err != nil {
+		t.Fatalf("expected handshake-only check to pass, got %v", err)
+	}
+}
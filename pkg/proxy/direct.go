@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// directDialTimeout 直连目标的超时
+const directDialTimeout = 10 * time.Second
+
+// lookupTimeout 路由规则解析域名的超时
+const lookupTimeout = 5 * time.Second
+
+// dialDirect 不经过 naive 直接连接目标，返回连接和结果的分类
+func dialDirect(target string) (net.Conn, string) {
+	conn, err := net.DialTimeout("tcp", target, directDialTimeout)
+	if err == nil {
+		return conn, OutcomeOK
+	}
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return nil, OutcomeConnectionRefused
+	case errors.Is(err, syscall.ENETUNREACH):
+		return nil, OutcomeNetworkUnreachable
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return nil, OutcomeDialTimeout
+	}
+	return nil, OutcomeHostUnreachable
+}
+
+// lookupHost 解析域名供路由规则匹配 IP，失败时返回空
+func lookupHost(host string) []netip.Addr {
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil
+	}
+	return addrs
+}
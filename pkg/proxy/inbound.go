@@ -0,0 +1,228 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// 入站协议
+const (
+	ProtocolSOCKS5      = "socks5"
+	ProtocolHTTPConnect = "http_connect"
+	ProtocolHTTP        = "http"
+)
+
+// inboundHandshakeTimeout 客户端完成代理请求的超时
+const inboundHandshakeTimeout = 30 * time.Second
+
+// Request 入站连接请求的目标
+type Request struct {
+	Protocol string
+	Target   string // host:port
+
+	httpReq *http.Request // 普通 HTTP 代理请求，连接上游后改写为源站格式转发
+}
+
+// errUnsupported 客户端请求了不支持的命令
+var errUnsupported = errors.New("unsupported command")
+
+// readRequest 按第一个字节识别 SOCKS5 或 HTTP 代理，读取请求的目标
+func readRequest(conn net.Conn, br *bufio.Reader) (*Request, error) {
+	first, err := br.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if first[0] == 5 {
+		return readSocksRequest(conn, br)
+	}
+	return readHTTPRequest(br)
+}
+
+// readSocksRequest 完成 SOCKS5 方法协商并读取 CONNECT 请求，只支持无认证
+func readSocksRequest(conn net.Conn, br *bufio.Reader) (*Request, error) {
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(br, head); err != nil {
+		return nil, err
+	}
+	methods := make([]byte, head[1])
+	if _, err := io.ReadFull(br, methods); err != nil {
+		return nil, err
+	}
+	if !slices.Contains(methods, 0) {
+		conn.Write([]byte{5, 0xFF})
+		return nil, errors.New("no acceptable authentication method")
+	}
+	if _, err := conn.Write([]byte{5, 0}); err != nil {
+		return nil, err
+	}
+
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(br, req); err != nil {
+		return nil, err
+	}
+	if req[0] != 5 {
+		return nil, fmt.Errorf("invalid SOCKS version %d", req[0])
+	}
+	var host string
+	switch req[3] {
+	case 1, 4:
+		ip := make([]byte, net.IPv4len)
+		if req[3] == 4 {
+			ip = make([]byte, net.IPv6len)
+		}
+		if _, err := io.ReadFull(br, ip); err != nil {
+			return nil, err
+		}
+		host = net.IP(ip).String()
+	case 3:
+		l, err := br.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		name := make([]byte, l)
+		if _, err := io.ReadFull(br, name); err != nil {
+			return nil, err
+		}
+		host = string(name)
+	default:
+		writeSocksReply(conn, 0x08)
+		return nil, fmt.Errorf("unsupported address type %d", req[3])
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(br, port); err != nil {
+		return nil, err
+	}
+	target := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+	if req[1] != 1 {
+		writeSocksReply(conn, 0x07)
+		return &Request{Protocol: ProtocolSOCKS5, Target: target}, errUnsupported
+	}
+	return &Request{Protocol: ProtocolSOCKS5, Target: target}, nil
+}
+
+// readHTTPRequest 读取 HTTP CONNECT 或绝对 URI 形式的普通 HTTP 代理请求
+func readHTTPRequest(br *bufio.Reader) (*Request, error) {
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return nil, err
+	}
+	if req.Method == http.MethodConnect {
+		if _, _, err := net.SplitHostPort(req.Host); err != nil {
+			return nil, fmt.Errorf("invalid CONNECT target %q", req.Host)
+		}
+		return &Request{Protocol: ProtocolHTTPConnect, Target: req.Host}, nil
+	}
+	if !req.URL.IsAbs() || req.URL.Scheme != "http" || req.URL.Host == "" {
+		return nil, fmt.Errorf("not a proxy request: %s", req.RequestURI)
+	}
+	target := req.URL.Host
+	if req.URL.Port() == "" {
+		target = net.JoinHostPort(req.URL.Hostname(), "80")
+	}
+	return &Request{Protocol: ProtocolHTTP, Target: target, httpReq: req}, nil
+}
+
+// reply 告知客户端上游连接的结果，成功时普通 HTTP 请求改写后发送给上游
+func (r *Request) reply(conn net.Conn, upstream net.Conn, outcome string) error {
+	switch r.Protocol {
+	case ProtocolSOCKS5:
+		return writeSocksReply(conn, socksReplyCode(outcome))
+	case ProtocolHTTPConnect:
+		if outcome != OutcomeOK {
+			return writeHTTPError(conn, outcome)
+		}
+		_, err := io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n")
+		return err
+	default:
+		if outcome != OutcomeOK {
+			return writeHTTPError(conn, outcome)
+		}
+		return forwardHTTPRequest(r.httpReq, upstream)
+	}
+}
+
+// forwardHTTPRequest 以源站格式发送请求，去掉代理相关的请求头
+// 同一连接上的后续请求可能指向其他主机，因此要求服务端在响应后关闭连接，客户端的连接也在响应后关闭（见 pipe）
+func forwardHTTPRequest(req *http.Request, upstream net.Conn) error {
+	req.Header.Del("Proxy-Connection")
+	req.Header.Del("Proxy-Authorization")
+	req.Close = true
+	return req.Write(upstream)
+}
+
+// copyHTTPResponse 将上游对 req 的响应转发给客户端，返回写给客户端的字节数
+// 响应改为 Connection: close，客户端的后续请求会建立新的连接，按各自的目标转发
+func copyHTTPResponse(client io.Writer, upstream net.Conn, req *http.Request) int64 {
+	w := &countingWriter{Writer: client}
+	br := bufio.NewReader(upstream)
+	for {
+		resp, err := http.ReadResponse(br, req)
+		if err != nil {
+			return w.n
+		}
+		// 100 Continue 等中间响应之后还有最终响应
+		interim := resp.StatusCode >= 100 && resp.StatusCode < 200
+		if !interim {
+			resp.Close = true
+			resp.Header.Del("Connection")
+			resp.Header.Del("Keep-Alive")
+		}
+		err = resp.Write(w)
+		resp.Body.Close()
+		if err != nil || !interim {
+			return w.n
+		}
+	}
+}
+
+// countingWriter 记录写入的字节数
+type countingWriter struct {
+	io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// socksReplyCode 连接结果对应的 SOCKS5 应答码
+func socksReplyCode(outcome string) byte {
+	if outcome == OutcomeDialTimeout {
+		return 0x04
+	}
+	for code, o := range replyOutcomes {
+		if o == outcome {
+			return code
+		}
+	}
+	return 0x01
+}
+
+func writeSocksReply(conn net.Conn, code byte) error {
+	_, err := conn.Write([]byte{5, code, 0, 1, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+func writeHTTPError(conn net.Conn, outcome string) error {
+	status := http.StatusBadGateway
+	switch outcome {
+	case OutcomeReplyTimeout, OutcomeTTLExpired, OutcomeDialTimeout:
+		status = http.StatusGatewayTimeout
+	case OutcomeNotAllowed:
+		status = http.StatusForbidden
+	}
+	body := outcome + "\n"
+	_, err := fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\nContent-Type: text/plain\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s",
+		status, http.StatusText(status), len(body), body)
+	return err
+}
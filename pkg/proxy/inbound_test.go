@@ -0,0 +1,234 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"naiveswitcher/internal/config"
+	"naiveswitcher/internal/sockstest"
+	"naiveswitcher/internal/types"
+	"naiveswitcher/pkg/health"
+	"naiveswitcher/pkg/rules"
+)
+
+// startSwitcher 启动入站监听，经由转发到真实目标的模拟 naive
+func startSwitcher(t *testing.T) (string, *types.GlobalState) {
+	t.Helper()
+	naiveAddr := sockstest.Serve(t, sockstest.Relay)
+
+	state := &types.GlobalState{
+		AppContext: context.Background(),
+		ErrorRate:  health.NewErrorRate(),
+		Outcomes:   health.NewOutcomes(),
+		Rules:      rules.NewEngine(nil),
+	}
+	state.Naive.Store(&types.NaiveProcess{Server: "https://u:p@upstream.example.com:443", Addr: naiveAddr})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	bufPool := &sync.Pool{New: func() any { return make([]byte, 32*1024) }}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go HandleConnection(state, config.NewConfig("test"), conn, bufPool, make(chan types.SwitchRequest, 10))
+		}
+	}()
+	return l.Addr().String(), state
+}
+
+func TestInboundProtocols(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Connection") != "" {
+			t.Errorf("proxy header forwarded: %v", r.Header)
+		}
+		io.WriteString(w, "hello "+r.URL.Path)
+	}))
+	defer srv.Close()
+	addr, state := startSwitcher(t)
+
+	for _, scheme := range []string{"socks5", "http"} {
+		client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(&url.URL{Scheme: scheme, Host: addr})}}
+		resp, err := client.Get(srv.URL + "/" + scheme)
+		if err != nil {
+			t.Fatalf("%s proxy: %v", scheme, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "hello /"+scheme {
+			t.Fatalf("%s proxy body = %q", scheme, body)
+		}
+	}
+
+	// HTTP CONNECT
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	target := srv.Listener.Addr().String()
+	io.WriteString(conn, "CONNECT "+target+" HTTP/1.1\r\nHost: "+target+"\r\n\r\n")
+	io.WriteString(conn, "GET /connect HTTP/1.1\r\nHost: "+target+"\r\nConnection: close\r\n\r\n")
+	data, _ := io.ReadAll(conn)
+	if got := string(data); !strings.Contains(got, "200 Connection Established") || !strings.Contains(got, "hello /connect") {
+		t.Fatalf("CONNECT response = %q", got)
+	}
+
+	outcomes := state.Outcomes.Snapshot()["https://u:p@upstream.example.com:443"]
+	if outcomes[OutcomeOK] == 0 {
+		t.Fatalf("unexpected outcomes: %v", outcomes)
+	}
+}
+
+func TestInboundRejectsNonProxyRequest(t *testing.T) {
+	addr, _ := startSwitcher(t)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET /path HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	if data, _ := io.ReadAll(conn); len(data) != 0 {
+		t.Fatalf("expected connection to be closed, got %q", data)
+	}
+}
+
+func TestInboundHTTPKeepAliveNotForwarded(t *testing.T) {
+	// 源站忽略 Connection: close，在同一连接上继续处理请求
+	origin, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer origin.Close()
+	var requests sync.Map
+	go func() {
+		for {
+			c, err := origin.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				br := bufio.NewReader(c)
+				for {
+					r, err := http.ReadRequest(br)
+					if err != nil {
+						return
+					}
+					requests.Store(r.URL.Path, true)
+					body := "hello " + r.URL.Path
+					io.WriteString(c, "HTTP/1.1 200 OK\r\nContent-Length: "+strconv.Itoa(len(body))+"\r\n\r\n"+body)
+				}
+			}(c)
+		}
+	}()
+	addr, _ := startSwitcher(t)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// 同一连接上的两个请求，第二个不能经由第一个请求的源站连接发出
+	host := origin.Addr().String()
+	io.WriteString(conn, "GET http://"+host+"/first HTTP/1.1\r\nHost: "+host+"\r\n\r\n")
+	io.WriteString(conn, "GET http://other.example.com/second HTTP/1.1\r\nHost: other.example.com\r\n\r\n")
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "hello /first" || !resp.Close {
+		t.Fatalf("response = %q, close = %v, want hello /first with Connection: close", body, resp.Close)
+	}
+	if _, err := http.ReadResponse(br, nil); err == nil {
+		t.Fatal("expected connection to be closed after the first response")
+	}
+	if _, ok := requests.Load("/second"); ok {
+		t.Fatal("second request was forwarded to the first origin")
+	}
+}
+
+func TestInboundRules(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "direct")
+	}))
+	defer srv.Close()
+	addr, state := startSwitcher(t)
+	// 模拟 naive 的地址不可用，只有直连能成功
+	state.Naive.Load().Addr = "127.0.0.1:1"
+	state.Rules.Update([]config.Rule{
+		{Name: "blocked", DomainSuffix: []string{"blocked.example"}, Action: config.ActionReject},
+		{Name: "local", IPCIDR: []string{"127.0.0.0/8"}, Action: config.ActionDirect},
+	})
+
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(&url.URL{Scheme: "http", Host: addr})}}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "direct" {
+		t.Fatalf("direct body = %q", body)
+	}
+
+	resp, err = client.Get("http://www.blocked.example/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("rejected status = %d", resp.StatusCode)
+	}
+
+	socks := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(&url.URL{Scheme: "socks5", Host: addr})}}
+	if _, err := socks.Get("http://blocked.example/"); err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Fatalf("expected SOCKS5 not allowed, got %v", err)
+	}
+
+	stats, unmatched := state.Rules.Snapshot()
+	if stats[0].Hits != 2 || stats[1].Hits != 1 || unmatched != 0 {
+		t.Fatalf("unexpected hits: %+v, unmatched %d", stats, unmatched)
+	}
+	if len(state.Outcomes.Snapshot()) != 0 {
+		t.Fatalf("direct and rejected connections should not be recorded: %v", state.Outcomes.Snapshot())
+	}
+}
+
+func TestDialTimeoutReply(t *testing.T) {
+	if code := socksReplyCode(OutcomeDialTimeout); code != 0x04 {
+		t.Fatalf("SOCKS5 reply code = %#x", code)
+	}
+	if UpstreamFailure(OutcomeDialTimeout) {
+		t.Fatal("direct dial timeout should not count against the server")
+	}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		writeHTTPError(server, OutcomeDialTimeout)
+		server.Close()
+	}()
+	data, _ := io.ReadAll(client)
+	if !strings.HasPrefix(string(data), "HTTP/1.1 504 ") {
+		t.Fatalf("unexpected HTTP reply: %q", data)
+	}
+}
@@ -1,27 +1,20 @@
 package proxy
 
 import (
-	"io"
+	"bufio"
 	"net"
+	"net/url"
+	"strconv"
 	"sync"
-	"sync/atomic"
 	"time"
 
+	"naiveswitcher/internal/config"
 	"naiveswitcher/internal/types"
-	"naiveswitcher/pkg/common"
 	"naiveswitcher/pkg/log"
-	"naiveswitcher/util"
 )
 
-// DataServerDown 定义服务器下线检测的数据模式
-var DataServerDown = map[[12]byte]struct{}{
-	{
-		5, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0,
-	}: {},
-}
-
 // ServeTCP 启动 TCP 代理服务器
-func ServeTCP(state *types.GlobalState, l net.Listener, doSwitch chan<- types.SwitchRequest) {
+func ServeTCP(state *types.GlobalState, cfg *config.Config, l net.Listener, doSwitch chan<- types.SwitchRequest) {
 	bufPool := &sync.Pool{
 		New: func() any {
 			return make([]byte, 32*1024)
@@ -33,82 +26,120 @@ func ServeTCP(state *types.GlobalState, l net.Listener, doSwitch chan<- types.Sw
 			log.DebugF("Error accepting connection: %v\n", err)
 			continue
 		}
-		go HandleConnection(state, conn, bufPool, doSwitch)
+		go HandleConnection(state, cfg, conn, bufPool, doSwitch)
 	}
 }
 
 // HandleConnection 处理单个连接
-func HandleConnection(state *types.GlobalState, conn net.Conn, bufPool *sync.Pool, doSwitch chan<- types.SwitchRequest) {
+// 在本地完成 SOCKS5 或 HTTP 代理握手得到目标地址，按路由规则直连、拒绝或经由当前 naive 进程的 SOCKS 端口连接目标
+func HandleConnection(state *types.GlobalState, cfg *config.Config, conn net.Conn, bufPool *sync.Pool, doSwitch chan<- types.SwitchRequest) {
 	defer func() {
 		conn.SetDeadline(time.Now())
 		conn.Close()
 	}()
 
-	if state.NaiveCmd == nil {
+	br := bufio.NewReader(conn)
+	conn.SetDeadline(time.Now().Add(inboundHandshakeTimeout))
+	req, err := readRequest(conn, br)
+	if err != nil {
+		log.DebugF("Inbound request error from %s: %v\n", conn.RemoteAddr(), err)
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	host, portStr, _ := net.SplitHostPort(req.Target)
+	port, _ := strconv.Atoi(portStr)
+	route := state.Rules.Match(host, port, lookupHost)
+	switch route.Action {
+	case config.ActionReject:
+		req.reply(conn, nil, OutcomeNotAllowed)
+		log.DebugF("%s %s rejected by %s\n", req.Protocol, req.Target, route.Name)
+		return
+	case config.ActionDirect:
+		start := time.Now()
+		upstream, outcome := dialDirect(req.Target)
+		outcome = relay(conn, br, req, upstream, outcome, bufPool, 0)
+		log.DebugF("%s %s direct by %s: %s (%s)\n", req.Protocol, req.Target, route.Name, outcome, time.Since(start).Round(time.Millisecond))
+		return
+	}
+
+	// 连接在整个生命周期内使用同一个 naive 进程，切换后旧进程等待计数归零再停止
+	naive := acquireNaive(state)
+	if naive == nil {
 		log.DebugF("No naive running\n")
+		req.reply(conn, nil, OutcomeGeneralFailure)
 		doSwitch <- types.SwitchRequest{Type: "auto"}
 		return
 	}
+	defer naive.Conns.Add(-1)
 
-	var serverDown bool = true
-	var remoteOk bool
+	current := cfg.Current()
+	start := time.Now()
+	upstream, outcome := dialUpstream(naive.Addr, req.Target)
+	outcome = relay(conn, br, req, upstream, outcome, bufPool, time.Duration(current.ErrorRate.FirstByteTimeout)*time.Second)
 
-	naiveConn, err := net.DialTimeout("tcp", common.UpstreamListenPort, 3*time.Second)
-	if err == nil {
-		go func() {
-			defer func() {
-				naiveConn.SetDeadline(time.Now())
-				naiveConn.Close()
-			}()
-			_, e := io.Copy(naiveConn, conn)
-			remoteOk = e == nil
-		}()
-		buf := bufPool.Get()
-		written, _ := io.CopyBuffer(util.NewDowngradeReaderWriter(conn), util.NewDowngradeReaderWriter(naiveConn), buf.([]byte))
-		serverDown = isServerDown(int(written), buf.([]byte), remoteOk)
-		bufPool.Put(buf)
-	}
+	serverDown := UpstreamFailure(outcome)
+	state.Outcomes.Add(naive.Server, outcome)
+	log.DebugF("%s %s via %s: %s (%s)\n", req.Protocol, req.Target, serverHost(naive.Server), outcome, time.Since(start).Round(time.Millisecond))
 
-	// 更新错误计数
-	if serverDown {
-		newCount := atomic.AddInt32(&state.ErrorCount, 1)
-		// 错误过多时触发切换
-		if newCount > 10 {
-			atomic.StoreInt32(&state.ErrorCount, 0)
-			log.DebugF("Too many errors (%d), switching server\n", newCount)
-			doSwitch <- types.SwitchRequest{
-				Type:        "avoid_auto",
-				AvoidServer: state.FastestUrl,
-			}
-		}
-	} else {
-		// 成功时减少错误计数（但不低于0）
-		decrementErrorCount(&state.ErrorCount)
+	// 按服务器统计窗口内的失败率，当前服务器超过阈值时切换
+	opts := current.ErrorRate
+	if opts.Window <= 0 {
+		return
+	}
+	stats := state.ErrorRate.Record(naive.Server, serverDown, current.ErrorWindow())
+	if !serverDown || stats.Total < opts.MinSamples || stats.Rate < opts.Threshold {
+		return
+	}
+	// 排空中的旧进程出错不影响当前服务器
+	if state.Naive.Load() != naive {
+		return
+	}
+	state.ErrorRate.Reset(naive.Server)
+	log.DebugF("Error rate %.1f%% (%d/%d in %ds) via %s, switching server\n",
+		stats.Rate, stats.Failures, stats.Total, opts.Window, naive.Server)
+	doSwitch <- types.SwitchRequest{
+		Type:        "avoid_auto",
+		AvoidServer: naive.Server,
 	}
 }
 
-// decrementErrorCount 原子地减少错误计数，但不会低于0
-func decrementErrorCount(count *int32) {
-	for {
-		old := atomic.LoadInt32(count)
-		if old <= 0 {
-			return
-		}
-		if atomic.CompareAndSwapInt32(count, old, old-1) {
-			return
+// relay 告知客户端连接结果，成功时双向转发数据，返回最终的结果分类
+func relay(conn net.Conn, br *bufio.Reader, req *Request, upstream net.Conn, outcome string, bufPool *sync.Pool, firstByteTimeout time.Duration) string {
+	if err := req.reply(conn, upstream, outcome); err != nil && outcome == OutcomeOK {
+		outcome = OutcomeClientClosed
+	}
+	if outcome != OutcomeOK {
+		if upstream != nil {
+			upstream.Close()
 		}
+		return outcome
 	}
+	buf := bufPool.Get()
+	defer bufPool.Put(buf)
+	return pipe(conn, br, upstream, buf.([]byte), req.httpReq, firstByteTimeout)
 }
 
-func isServerDown(written int, data []byte, remoteOk bool) bool {
-	if remoteOk {
-		return false
+// serverHost 返回服务器的主机名，日志中不输出凭据
+func serverHost(server string) string {
+	if u, err := url.Parse(server); err == nil {
+		return u.Hostname()
 	}
-	if written != 12 {
-		return false
+	return server
+}
+
+// acquireNaive 返回当前 naive 进程并增加其连接计数，没有运行的进程时返回 nil
+// 计数后再次确认进程没有被替换，否则旧进程可能已在计数前被判定空闲而停止
+func acquireNaive(state *types.GlobalState) *types.NaiveProcess {
+	for {
+		naive := state.Naive.Load()
+		if naive == nil {
+			return nil
+		}
+		naive.Conns.Add(1)
+		if state.Naive.Load() == naive {
+			return naive
+		}
+		naive.Conns.Add(-1)
 	}
-	var key [12]byte
-	copy(key[:], data[:12])
-	_, isDown := DataServerDown[key]
-	return isDown
 }
@@ -0,0 +1,216 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"naiveswitcher/util"
+)
+
+// 连接结果的分类
+const (
+	OutcomeOK                  = "ok"
+	OutcomeGeneralFailure      = "general_failure"       // REP 0x01
+	OutcomeNotAllowed          = "not_allowed"           // REP 0x02
+	OutcomeNetworkUnreachable  = "network_unreachable"   // REP 0x03
+	OutcomeHostUnreachable     = "host_unreachable"      // REP 0x04
+	OutcomeConnectionRefused   = "connection_refused"    // REP 0x05
+	OutcomeTTLExpired          = "ttl_expired"           // REP 0x06
+	OutcomeCommandNotSupported = "command_not_supported" // REP 0x07
+	OutcomeAddressNotSupported = "address_not_supported" // REP 0x08
+	OutcomeDialFailed          = "dial_failed"           // 无法连接本地 naive
+	OutcomeReplyTimeout        = "reply_timeout"         // naive 在超时内没有完成握手
+	OutcomeClosedBeforeReply   = "closed_before_reply"   // naive 在 CONNECT 应答前断开
+	OutcomeClosedAfterReply    = "closed_after_reply"    // CONNECT 成功后没有任何数据就被 naive 断开
+	OutcomeFirstByteTimeout    = "first_byte_timeout"    // 客户端发送数据后超时仍没有收到任何数据
+	OutcomeClientClosed        = "client_closed"         // 客户端在收到数据前主动关闭
+	OutcomeClientError         = "client_error"          // 客户端请求格式错误或不支持的命令
+	OutcomeDialTimeout         = "dial_timeout"          // 直连目标超时，按 REP 0x04 应答
+	outcomeUnknownReply        = "unknown_reply"
+)
+
+// replyOutcomes SOCKS5 应答码对应的分类
+var replyOutcomes = map[byte]string{
+	0x00: OutcomeOK,
+	0x01: OutcomeGeneralFailure,
+	0x02: OutcomeNotAllowed,
+	0x03: OutcomeNetworkUnreachable,
+	0x04: OutcomeHostUnreachable,
+	0x05: OutcomeConnectionRefused,
+	0x06: OutcomeTTLExpired,
+	0x07: OutcomeCommandNotSupported,
+	0x08: OutcomeAddressNotSupported,
+}
+
+// upstreamHandshakeTimeout 与 naive 完成 SOCKS5 握手和 CONNECT 的超时
+const upstreamHandshakeTimeout = 10 * time.Second
+
+// UpstreamFailure 该分类是否归因于上游服务器
+// 目标主机不可达、拒绝连接等是目标的问题，客户端的错误也不计入服务器
+func UpstreamFailure(outcome string) bool {
+	switch outcome {
+	case OutcomeGeneralFailure, OutcomeTTLExpired, OutcomeDialFailed, OutcomeReplyTimeout,
+		OutcomeClosedBeforeReply, OutcomeClosedAfterReply, OutcomeFirstByteTimeout, outcomeUnknownReply:
+		return true
+	}
+	return false
+}
+
+// dialUpstream 经由 naive 的 SOCKS5 端口 CONNECT 到 target，返回连接和握手结果的分类
+func dialUpstream(addr string, target string) (net.Conn, string) {
+	conn, err := net.DialTimeout("tcp", addr, 3*time.Second)
+	if err != nil {
+		return nil, OutcomeDialFailed
+	}
+	conn.SetDeadline(time.Now().Add(upstreamHandshakeTimeout))
+	outcome := connectUpstream(conn, target)
+	if outcome != OutcomeOK {
+		conn.Close()
+		return nil, outcome
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, OutcomeOK
+}
+
+func connectUpstream(conn net.Conn, target string) string {
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return OutcomeClientError
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return OutcomeClientError
+	}
+	req := []byte{5, 1, 0, 5, 1, 0}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+		req = append(append(req, 1), ip.To4()...)
+	} else if ip != nil {
+		req = append(append(req, 4), ip.To16()...)
+	} else if len(host) <= 255 {
+		req = append(append(req, 3, byte(len(host))), host...)
+	} else {
+		return OutcomeClientError
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return upstreamError(err)
+	}
+
+	// 方法选择应答和 CONNECT 应答：VER REP RSV ATYP BND.ADDR BND.PORT
+	head := make([]byte, 6)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return upstreamError(err)
+	}
+	if head[0] != 5 || head[1] != 0 || head[2] != 5 {
+		return outcomeUnknownReply
+	}
+	if err := readAddr(conn, head[5]); err != nil {
+		return upstreamError(err)
+	}
+	outcome, ok := replyOutcomes[head[3]]
+	if !ok {
+		return outcomeUnknownReply
+	}
+	return outcome
+}
+
+// upstreamError 与 naive 握手时读写出错的分类
+func upstreamError(err error) string {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return OutcomeReplyTimeout
+	}
+	return OutcomeClosedBeforeReply
+}
+
+// readAddr 读取 SOCKS5 地址和端口
+func readAddr(r io.Reader, atyp byte) error {
+	var n int
+	switch atyp {
+	case 1:
+		n = net.IPv4len
+	case 4:
+		n = net.IPv6len
+	case 3:
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(r, l); err != nil {
+			return err
+		}
+		n = int(l[0])
+	default:
+		return errors.New("unknown address type")
+	}
+	_, err := io.ReadFull(r, make([]byte, n+2))
+	return err
+}
+
+// pipe 在握手完成后双向转发数据，返回连接结果的分类
+// httpReq 非空表示握手时已经向上游发送了该普通 HTTP 代理请求，只转发它的响应，firstByteTimeout 为 0 时不判断首字节超时
+func pipe(client io.ReadWriteCloser, clientReader io.Reader, upstream net.Conn, buf []byte, httpReq *http.Request, firstByteTimeout time.Duration) string {
+	var clientDone atomic.Bool // 客户端一侧已结束（EOF、重置或超时）
+	var sentAt atomic.Int64    // 客户端第一次发送数据的时间
+	if httpReq != nil {
+		sentAt.Store(time.Now().UnixNano())
+	}
+	// 协议升级后的连接不再是 HTTP，仍按原始数据转发
+	oneRequest := httpReq != nil && httpReq.Header.Get("Upgrade") == ""
+
+	go func() {
+		defer func() {
+			upstream.SetDeadline(time.Now())
+			upstream.Close()
+		}()
+		dst := io.Writer(upstream)
+		if oneRequest {
+			// 同一连接上的后续请求可能指向其他主机，不能发给当前源站，只读取以发现客户端关闭
+			dst = io.Discard
+		}
+		io.Copy(dst, &trackedReader{Reader: clientReader, at: &sentAt, done: &clientDone})
+	}()
+
+	startedAt := time.Now().UnixNano()
+	var written int64
+	if oneRequest {
+		written = copyHTTPResponse(client, upstream, httpReq)
+	} else {
+		written, _ = io.CopyBuffer(util.NewDowngradeReaderWriter(client), util.NewDowngradeReaderWriter(upstream), buf)
+	}
+	switch {
+	case written > 0:
+		return OutcomeOK
+	case !clientDone.Load():
+		// 客户端仍在等待时上游先结束
+		return OutcomeClosedAfterReply
+	case firstByteTimeout > 0 && sentAt.Load() != 0:
+		// 从客户端发送数据（或握手完成，取较晚者）开始等待
+		waited := time.Since(time.Unix(0, max(sentAt.Load(), startedAt)))
+		if waited >= firstByteTimeout {
+			return OutcomeFirstByteTimeout
+		}
+	}
+	return OutcomeClientClosed
+}
+
+// trackedReader 记录第一次读到数据的时间，以及读取是否已结束
+type trackedReader struct {
+	io.Reader
+	at   *atomic.Int64
+	done *atomic.Bool
+}
+
+func (r *trackedReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 && r.at.Load() == 0 {
+		r.at.Store(time.Now().UnixNano())
+	}
+	if err != nil {
+		r.done.Store(true)
+	}
+	return n, err
+}
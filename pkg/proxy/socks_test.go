@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"naiveswitcher/internal/sockstest"
+)
+
+func TestDialUpstreamOutcomes(t *testing.T) {
+	cases := []struct {
+		name  string
+		serve sockstest.Handler
+		want  string
+	}{
+		{"ok", sockstest.Reply(0), OutcomeOK},
+		{"general failure", sockstest.Reply(1), OutcomeGeneralFailure},
+		{"host unreachable", sockstest.Reply(4), OutcomeHostUnreachable},
+		{"connection refused", sockstest.Reply(5), OutcomeConnectionRefused},
+		{"ttl expired", sockstest.Reply(6), OutcomeTTLExpired},
+		{"closed before reply", func(c net.Conn, target string) {}, OutcomeClosedBeforeReply},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, target := range []string{"example.com:443", "1.2.3.4:80", "[2001:db8::1]:443"} {
+				conn, got := dialUpstream(sockstest.Serve(t, tc.serve), target)
+				if conn != nil {
+					conn.Close()
+				}
+				if got != tc.want {
+					t.Fatalf("%s: outcome = %s, want %s", target, got, tc.want)
+				}
+			}
+		})
+	}
+
+	if _, got := dialUpstream("127.0.0.1:1", "example.com:443"); got != OutcomeDialFailed {
+		t.Fatalf("outcome = %s, want %s", got, OutcomeDialFailed)
+	}
+	for outcome, want := range map[string]bool{
+		OutcomeGeneralFailure:    true,
+		OutcomeClosedAfterReply:  true,
+		OutcomeHostUnreachable:   false,
+		OutcomeConnectionRefused: false,
+		OutcomeClientClosed:      false,
+	} {
+		if UpstreamFailure(outcome) != want {
+			t.Errorf("UpstreamFailure(%s) = %v, want %v", outcome, !want, want)
+		}
+	}
+}
+
+func TestPipeOutcomes(t *testing.T) {
+	cases := []struct {
+		name   string
+		client func(c net.Conn)
+		serve  func(c net.Conn)
+		want   string
+	}{
+		{
+			name: "ok",
+			client: func(c net.Conn) {
+				c.Write([]byte("ping"))
+				io.ReadFull(c, make([]byte, 4))
+			},
+			serve: func(c net.Conn) {
+				io.ReadFull(c, make([]byte, 4))
+				c.Write([]byte("pong"))
+			},
+			want: OutcomeOK,
+		},
+		{
+			name: "closed after reply",
+			client: func(c net.Conn) {
+				c.Write([]byte("ping"))
+				io.Copy(io.Discard, c)
+			},
+			serve: func(c net.Conn) {},
+			want:  OutcomeClosedAfterReply,
+		},
+		{
+			name: "first byte timeout",
+			client: func(c net.Conn) {
+				c.Write([]byte("ping"))
+				time.Sleep(200 * time.Millisecond)
+			},
+			serve: func(c net.Conn) { io.Copy(io.Discard, c) },
+			want:  OutcomeFirstByteTimeout,
+		},
+		{
+			name:   "client closed",
+			client: func(c net.Conn) {},
+			serve:  func(c net.Conn) { io.Copy(io.Discard, c) },
+			want:   OutcomeClientClosed,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			upstream, outcome := dialUpstream(sockstest.Serve(t, func(c net.Conn, target string) {
+				c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+				tc.serve(c)
+			}), "example.com:443")
+			if outcome != OutcomeOK {
+				t.Fatalf("dial outcome = %s", outcome)
+			}
+			clientSide, proxySide := net.Pipe()
+			go func() {
+				defer clientSide.Close()
+				tc.client(clientSide)
+			}()
+			got := pipe(proxySide, proxySide, upstream, make([]byte, 32*1024), nil, 100*time.Millisecond)
+			proxySide.Close()
+			if got != tc.want {
+				t.Fatalf("outcome = %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+// resetReader 模拟客户端发送 RST
+type resetReader struct{}
+
+func (resetReader) Read([]byte) (int, error) { return 0, syscall.ECONNRESET }
+
+func TestPipeClientReset(t *testing.T) {
+	upstream, outcome := dialUpstream(sockstest.Serve(t, sockstest.Reply(0)), "example.com:443")
+	if outcome != OutcomeOK {
+		t.Fatalf("dial outcome = %s", outcome)
+	}
+	clientSide, proxySide := net.Pipe()
+	defer clientSide.Close()
+	defer proxySide.Close()
+	if got := pipe(proxySide, resetReader{}, upstream, make([]byte, 32*1024), nil, 0); got != OutcomeClientClosed {
+		t.Fatalf("outcome = %s, want %s", got, OutcomeClientClosed)
+	}
+}
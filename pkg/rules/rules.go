@@ -0,0 +1,248 @@
+package rules
+
+import (
+	"net/netip"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"naiveswitcher/internal/config"
+)
+
+// Stats 单条规则的命中统计
+type Stats struct {
+	Index  int    `json:"index"`
+	Name   string `json:"name"`
+	Action string `json:"action"`
+	Hits   int64  `json:"hits"`
+}
+
+// Match 一次匹配的结果，Index 为 -1 表示没有规则匹配，使用默认动作
+type Match struct {
+	Action string
+	Index  int
+	Name   string
+}
+
+// Geo 规则匹配使用的 GeoIP 和 GeoSite 数据库
+type Geo interface {
+	Country(addr netip.Addr) string             // IP 所属国家的代码（小写），未知时为空
+	InSite(category string, domain string) bool // 域名是否属于 geosite 分类
+}
+
+// geoIPPrivate 匹配内网、回环等非公网地址，不需要数据库
+const geoIPPrivate = "private"
+
+// Engine 按顺序匹配路由规则并统计命中次数，规则变更后整体替换
+type Engine struct {
+	mu     sync.RWMutex
+	geo    Geo
+	source []config.Rule
+	rules  []*rule
+	misses atomic.Int64 // 未匹配任何规则的连接数
+}
+
+type rule struct {
+	name     string
+	action   string
+	suffixes []string
+	keywords []string
+	regexes  []*regexp.Regexp
+	prefixes []netip.Prefix
+	ports    [][2]int
+	geoIP    []string
+	geoSite  []string
+	resolve  bool
+	hits     atomic.Int64
+}
+
+// NewEngine 创建路由规则引擎，geo 为 nil 时 geoip（private 除外）和 geosite 条件不会匹配
+func NewEngine(geo Geo) *Engine {
+	return &Engine{geo: geo}
+}
+
+// Update 应用新的规则，规则没有变化时保留命中统计，返回规则是否有变化
+func (e *Engine) Update(rules []config.Rule) (bool, error) {
+	e.mu.RLock()
+	same := reflect.DeepEqual(e.source, rules)
+	e.mu.RUnlock()
+	if same {
+		return false, nil
+	}
+
+	compiled := make([]*rule, 0, len(rules))
+	for i, r := range rules {
+		c, err := compile(r, i)
+		if err != nil {
+			return false, err
+		}
+		compiled = append(compiled, c)
+	}
+
+	e.mu.Lock()
+	e.source = rules
+	e.rules = compiled
+	e.misses.Store(0)
+	e.mu.Unlock()
+	return true, nil
+}
+
+func compile(r config.Rule, index int) (*rule, error) {
+	c := &rule{name: r.Label(index), action: r.Action, resolve: r.Resolve}
+	for _, s := range r.DomainSuffix {
+		c.suffixes = append(c.suffixes, normalizeDomain(s))
+	}
+	for _, s := range r.DomainKeyword {
+		c.keywords = append(c.keywords, strings.ToLower(s))
+	}
+	for _, p := range r.DomainRegex {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+		c.regexes = append(c.regexes, re)
+	}
+	for _, s := range r.IPCIDR {
+		p, err := config.ParsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		c.prefixes = append(c.prefixes, p)
+	}
+	for _, s := range r.GeoIP {
+		c.geoIP = append(c.geoIP, strings.ToLower(s))
+	}
+	for _, s := range r.GeoSite {
+		c.geoSite = append(c.geoSite, strings.ToLower(s))
+	}
+	for _, s := range r.Port {
+		lo, hi, err := config.ParsePortRange(s)
+		if err != nil {
+			return nil, err
+		}
+		c.ports = append(c.ports, [2]int{lo, hi})
+	}
+	return c, nil
+}
+
+// Match 返回目标地址匹配的第一条规则并计数
+// lookup 在规则需要时解析域名，每次匹配最多调用一次，为 nil 时不解析
+func (e *Engine) Match(host string, port int, lookup func(host string) []netip.Addr) Match {
+	t := &target{host: normalizeDomain(host), port: port, lookup: lookup}
+	if addr, err := netip.ParseAddr(strings.Trim(host, "[]")); err == nil {
+		t.host = ""
+		t.addrs = []netip.Addr{addr.Unmap()}
+		t.resolved = true
+	}
+
+	// 规则列表只会整体替换，匹配时不持有锁，避免解析域名阻塞 Update 和其他连接
+	e.mu.RLock()
+	rules := e.rules
+	e.mu.RUnlock()
+	for i, r := range rules {
+		if r.match(t, e.geo) {
+			r.hits.Add(1)
+			return Match{Action: r.action, Index: i, Name: r.name}
+		}
+	}
+	e.misses.Add(1)
+	return Match{Action: config.ActionProxy, Index: -1}
+}
+
+// Snapshot 返回各规则的命中次数和未匹配的连接数
+func (e *Engine) Snapshot() ([]Stats, int64) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	stats := make([]Stats, 0, len(e.rules))
+	for i, r := range e.rules {
+		stats = append(stats, Stats{Index: i, Name: r.name, Action: r.action, Hits: r.hits.Load()})
+	}
+	return stats, e.misses.Load()
+}
+
+// target 待匹配的目标，域名目标的 IP 按需解析
+type target struct {
+	host     string // 域名，目标为 IP 时为空
+	port     int
+	addrs    []netip.Addr
+	resolved bool
+	lookup   func(host string) []netip.Addr
+}
+
+func (t *target) ips(resolve bool) []netip.Addr {
+	if !t.resolved && resolve && t.lookup != nil {
+		t.resolved = true
+		for _, addr := range t.lookup(t.host) {
+			t.addrs = append(t.addrs, addr.Unmap())
+		}
+	}
+	return t.addrs
+}
+
+func (r *rule) match(t *target, geo Geo) bool {
+	if len(r.suffixes) > 0 && !matchAny(r.suffixes, func(s string) bool {
+		return t.host == s || strings.HasSuffix(t.host, "."+s)
+	}) {
+		return false
+	}
+	if len(r.keywords) > 0 && !matchAny(r.keywords, func(k string) bool {
+		return t.host != "" && strings.Contains(t.host, k)
+	}) {
+		return false
+	}
+	if len(r.regexes) > 0 && !matchAny(r.regexes, func(re *regexp.Regexp) bool {
+		return t.host != "" && re.MatchString(t.host)
+	}) {
+		return false
+	}
+	if len(r.ports) > 0 && !matchAny(r.ports, func(p [2]int) bool {
+		return t.port >= p[0] && t.port <= p[1]
+	}) {
+		return false
+	}
+	if len(r.geoSite) > 0 && !matchAny(r.geoSite, func(category string) bool {
+		return t.host != "" && geo != nil && geo.InSite(category, t.host)
+	}) {
+		return false
+	}
+	if len(r.prefixes) > 0 && !matchAny(t.ips(r.resolve), func(addr netip.Addr) bool {
+		return matchAny(r.prefixes, func(p netip.Prefix) bool { return p.Contains(addr) })
+	}) {
+		return false
+	}
+	if len(r.geoIP) > 0 && !matchAny(t.ips(r.resolve), func(addr netip.Addr) bool {
+		return matchAny(r.geoIP, func(code string) bool {
+			if code == geoIPPrivate {
+				return isPrivate(addr)
+			}
+			return geo != nil && geo.Country(addr) == code
+		})
+	}) {
+		return false
+	}
+	return true
+}
+
+// sharedAddressSpace 运营商级 NAT 使用的地址段
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// isPrivate 是否为内网、回环、链路本地等非公网地址
+func isPrivate(addr netip.Addr) bool {
+	return addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() || addr.IsUnspecified() ||
+		sharedAddressSpace.Contains(addr)
+}
+
+func matchAny[T any](values []T, f func(T) bool) bool {
+	for _, v := range values {
+		if f(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeDomain(s string) string {
+	return strings.ToLower(strings.Trim(s, "."))
+}
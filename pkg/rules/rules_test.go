@@ -0,0 +1,142 @@
+package rules
+
+import (
+	"net/netip"
+	"testing"
+
+	"naiveswitcher/internal/config"
+)
+
+func TestEngineMatch(t *testing.T) {
+	e := NewEngine(nil)
+	_, err := e.Update([]config.Rule{
+		{Name: "lan", IPCIDR: []string{"192.168.0.0/16", "fd00::/8"}, Action: config.ActionDirect},
+		{Name: "ads", DomainKeyword: []string{"adservice"}, Action: config.ActionReject},
+		{Name: "cn", DomainSuffix: []string{"example.cn"}, Port: []string{"443"}, Action: config.ActionDirect},
+		{Name: "stun", DomainRegex: []string{`^stun\.`}, Port: []string{"3478-3480"}, Action: config.ActionReject},
+		{Name: "resolved", IPCIDR: []string{"10.0.0.0/8"}, Resolve: true, Action: config.ActionDirect},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookups := 0
+	lookup := func(host string) []netip.Addr {
+		lookups++
+		if host == "nas.home" {
+			return []netip.Addr{netip.MustParseAddr("10.1.2.3")}
+		}
+		return nil
+	}
+
+	cases := []struct {
+		host string
+		port int
+		want string
+	}{
+		{"192.168.1.10", 80, "lan"},
+		{"fd12::1", 80, "lan"},
+		{"pagead.adservice.google.com", 443, "ads"},
+		{"www.Example.CN.", 443, "cn"},
+		{"example.cn", 443, "cn"},
+		{"badexample.cn", 443, ""},
+		{"www.example.cn", 80, ""},
+		{"stun.l.example.com", 3479, "stun"},
+		{"stun.l.example.com", 443, ""},
+		{"nas.home", 80, "resolved"},
+		{"10.0.0.1", 80, "resolved"},
+	}
+	for _, c := range cases {
+		got := e.Match(c.host, c.port, lookup)
+		if got.Name != c.want {
+			t.Errorf("Match(%s, %d) = %q, want %q", c.host, c.port, got.Name, c.want)
+		}
+		if c.want == "" && (got.Action != config.ActionProxy || got.Index != -1) {
+			t.Errorf("Match(%s, %d) = %+v, want default proxy", c.host, c.port, got)
+		}
+	}
+	// 只有到达需要解析的规则时才解析，IP 目标不解析
+	if lookups != 4 {
+		t.Errorf("lookups = %d, want 4", lookups)
+	}
+
+	stats, unmatched := e.Snapshot()
+	if stats[0].Hits != 2 || stats[2].Hits != 2 || stats[4].Hits != 2 || unmatched != 3 {
+		t.Fatalf("unexpected stats: %+v, unmatched %d", stats, unmatched)
+	}
+}
+
+func TestEngineUpdateKeepsHits(t *testing.T) {
+	e := NewEngine(nil)
+	rules := []config.Rule{{DomainSuffix: []string{"lan"}, Action: config.ActionDirect}}
+	if changed, _ := e.Update(rules); !changed {
+		t.Fatal("first update should change rules")
+	}
+	e.Match("nas.lan", 80, nil)
+	if changed, _ := e.Update([]config.Rule{{DomainSuffix: []string{"lan"}, Action: config.ActionDirect}}); changed {
+		t.Fatal("same rules should not change")
+	}
+	stats, _ := e.Snapshot()
+	if stats[0].Hits != 1 || stats[0].Name != "rules[0]" {
+		t.Fatalf("hits should be kept: %+v", stats)
+	}
+
+	e.Update([]config.Rule{{DomainSuffix: []string{"lan"}, Action: config.ActionReject}})
+	stats, _ = e.Snapshot()
+	if stats[0].Hits != 0 || stats[0].Action != config.ActionReject {
+		t.Fatalf("hits should be reset: %+v", stats)
+	}
+}
+
+// fakeGeo 固定的 GeoIP 和 GeoSite 数据
+type fakeGeo struct{}
+
+func (fakeGeo) Country(addr netip.Addr) string {
+	if netip.MustParsePrefix("1.0.0.0/8").Contains(addr) {
+		return "cn"
+	}
+	return "us"
+}
+
+func (fakeGeo) InSite(category string, domain string) bool {
+	return category == "cn" && domain == "www.baidu.com"
+}
+
+func TestEngineGeo(t *testing.T) {
+	rules := []config.Rule{
+		{Name: "private", GeoIP: []string{"private"}, Action: config.ActionDirect},
+		{Name: "geosite", GeoSite: []string{"CN"}, Action: config.ActionDirect},
+		{Name: "geoip", GeoIP: []string{"CN"}, Resolve: true, Action: config.ActionDirect},
+	}
+	lookup := func(host string) []netip.Addr {
+		return []netip.Addr{netip.MustParseAddr("1.1.1.1")}
+	}
+	cases := []struct {
+		host string
+		want string
+	}{
+		{"192.168.1.1", "private"},
+		{"100.64.0.1", "private"},
+		{"::1", "private"},
+		{"www.baidu.com", "geosite"},
+		{"1.2.3.4", "geoip"},
+		{"qq.com", "geoip"},
+		{"8.8.8.8", ""},
+	}
+	e := NewEngine(fakeGeo{})
+	e.Update(rules)
+	for _, c := range cases {
+		if got := e.Match(c.host, 443, lookup); got.Name != c.want {
+			t.Errorf("Match(%s) = %q, want %q", c.host, got.Name, c.want)
+		}
+	}
+
+	// 没有数据库时只有 private 生效
+	e = NewEngine(nil)
+	e.Update(rules)
+	if got := e.Match("1.2.3.4", 443, lookup); got.Index != -1 {
+		t.Errorf("Match without geo = %+v", got)
+	}
+	if got := e.Match("10.0.0.1", 443, lookup); got.Name != "private" {
+		t.Errorf("Match private without geo = %+v", got)
+	}
+}
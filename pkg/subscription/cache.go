@@ -0,0 +1,82 @@
+package subscription
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"naiveswitcher/internal/config"
+	"naiveswitcher/util"
+)
+
+const cacheFile = "subscription_cache.json"
+
+// cachedSource 订阅源最近一次成功抓取的结果
+type cachedSource struct {
+	Nodes     []Node    `json:"nodes"`
+	Format    string    `json:"format"`
+	FetchedAt time.Time `json:"fetched_at"`
+	UserInfo  *UserInfo `json:"userinfo,omitempty"`
+
+	Validators
+}
+
+func cachePath(basePath string) string {
+	return filepath.Join(basePath, cacheFile)
+}
+
+// LoadCache 从磁盘加载各订阅源上一次成功的结果，启动时在网络抓取前调用
+func (p *Pool) LoadCache(sources []config.SubscriptionSource) error {
+	p.sync(sources)
+
+	data, err := os.ReadFile(cachePath(p.basePath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var cached map[string]cachedSource
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for name, c := range cached {
+		st, ok := p.sources[name]
+		if !ok || len(st.nodes) > 0 {
+			continue
+		}
+		st.nodes = c.Nodes
+		st.status.HostCount = len(c.Nodes)
+		st.status.Format = c.Format
+		st.status.LastSuccess = c.FetchedAt
+		st.status.UserInfo = c.UserInfo
+		st.validators = c.Validators
+	}
+	return nil
+}
+
+// saveCacheUnsafe 原子地写入各订阅源上一次成功的结果（需要外部已获取锁）
+func (p *Pool) saveCacheUnsafe() error {
+	cached := make(map[string]cachedSource, len(p.sources))
+	for name, st := range p.sources {
+		if len(st.nodes) == 0 {
+			continue
+		}
+		cached[name] = cachedSource{
+			Nodes:      st.nodes,
+			Format:     st.status.Format,
+			FetchedAt:  st.status.LastSuccess,
+			UserInfo:   st.status.UserInfo,
+			Validators: st.validators,
+		}
+	}
+	data, err := json.Marshal(cached)
+	if err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(cachePath(p.basePath), data, 0o600)
+}
@@ -0,0 +1,116 @@
+package subscription
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+
+	"naiveswitcher/internal/config"
+	"naiveswitcher/pkg/log"
+)
+
+// Node 服务器节点，Name 来自订阅中的备注（如 URL 的 #fragment）
+type Node struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// newNode 创建节点，没有备注时使用主机名作为名称
+func newNode(name string, hostUrl string) Node {
+	if name == "" {
+		if u, err := url.Parse(hostUrl); err == nil {
+			name = u.Hostname()
+		}
+	}
+	return Node{Name: name, URL: hostUrl}
+}
+
+// Hostname 返回节点的主机名
+func (n Node) Hostname() string {
+	u, err := url.Parse(n.URL)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// URLs 返回节点的 URL 列表
+func URLs(nodes []Node) []string {
+	urls := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		urls = append(urls, n.URL)
+	}
+	return urls
+}
+
+// FindNode 按 URL 或名称查找节点，URL 优先
+func FindNode(nodes []Node, key string) (Node, bool) {
+	for _, n := range nodes {
+		if n.URL == key {
+			return n, true
+		}
+	}
+	for _, n := range nodes {
+		if n.Name == key {
+			return n, true
+		}
+	}
+	return Node{}, false
+}
+
+// Filter 按 include/exclude 正则过滤节点，名称或主机名匹配即视为命中
+// include 为空时保留所有未被 exclude 的节点
+func Filter(nodes []Node, filter config.NodeFilter) []Node {
+	if len(filter.Include) == 0 && len(filter.Exclude) == 0 {
+		return nodes
+	}
+	include, err := compileAll(filter.Include)
+	if err != nil {
+		log.DebugF("Invalid include filter: %v\n", err)
+		return nodes
+	}
+	exclude, err := compileAll(filter.Exclude)
+	if err != nil {
+		log.DebugF("Invalid exclude filter: %v\n", err)
+		return nodes
+	}
+
+	filtered := make([]Node, 0, len(nodes))
+	for _, n := range nodes {
+		host := n.Hostname()
+		if len(include) > 0 && !matchAny(include, n.Name, host) {
+			continue
+		}
+		if matchAny(exclude, n.Name, host) {
+			continue
+		}
+		filtered = append(filtered, n)
+	}
+	if len(filtered) != len(nodes) {
+		log.DebugF("Filtered out %d of %d nodes\n", len(nodes)-len(filtered), len(nodes))
+	}
+	return filtered
+}
+
+func compileAll(patterns []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", p, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+func matchAny(res []*regexp.Regexp, values ...string) bool {
+	for _, re := range res {
+		for _, v := range values {
+			if v != "" && re.MatchString(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package subscription
+
+import (
+	"testing"
+
+	"naiveswitcher/internal/config"
+)
+
+func TestNodeNamesFromRemarks(t *testing.T) {
+	nodes, _, _, err := Parse([]byte("naive+https://u:p@hk.example.com:443#%E9%A6%99%E6%B8%AF%2001\nhttps://u:p@us.example.com:443\n"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if nodes[0].Name != "香港 01" {
+		t.Fatalf("unexpected name: %q", nodes[0].Name)
+	}
+	if nodes[1].Name != "us.example.com" {
+		t.Fatalf("expected hostname as fallback name, got %q", nodes[1].Name)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	nodes := []Node{
+		{Name: "香港 01", URL: "https://u:p@hk1.example.com:443"},
+		{Name: "香港 02", URL: "https://u:p@hk2.example.ru:443"},
+		{Name: "日本 01", URL: "https://u:p@jp1.example.com:443"},
+	}
+	got := Filter(nodes, config.NodeFilter{Include: []string{"香港"}, Exclude: []string{`\.ru$`}})
+	if len(got) != 1 || got[0].Name != "香港 01" {
+		t.Fatalf("unexpected filter result: %+v", got)
+	}
+
+	if n, ok := FindNode(nodes, "日本 01"); !ok || n.URL != "https://u:p@jp1.example.com:443" {
+		t.Fatalf("FindNode by name failed: %+v", n)
+	}
+}
@@ -0,0 +1,87 @@
+package subscription
+
+import (
+	"encoding/base64"
+	"reflect"
+	"testing"
+)
+
+func TestParseFormats(t *testing.T) {
+	legacy := "naive+https://" + base64.StdEncoding.EncodeToString([]byte("u:p@legacy.example.com:443"))
+	cases := []struct {
+		name    string
+		body    string
+		format  string
+		hosts   []string
+		skipped int
+	}{
+		{
+			name:   "legacy base64 of base64 host",
+			body:   base64.StdEncoding.EncodeToString([]byte(legacy)),
+			format: "lines",
+			hosts:  []string{"https://u:p@legacy.example.com:443"},
+		},
+		{
+			name: "plain lines",
+			body: "naive+https://u:p@a.example.com:443#A\n" +
+				"naive+quic://u:p@b.example.com:443\n" +
+				"https://u:p@c.example.com:8443\n" +
+				"vmess://not-supported\n" +
+				"garbage line\n",
+			format: "lines",
+			hosts: []string{
+				"https://u:p@a.example.com:443",
+				"quic://u:p@b.example.com:443",
+				"https://u:p@c.example.com:8443",
+			},
+			skipped: 2,
+		},
+		{
+			name: "sip008 json",
+			body: `{"version":1,"servers":[
+				{"server":"a.example.com","server_port":443,"username":"u","password":"p","method":"naive"},
+				{"server":"ss.example.com","server_port":8388,"password":"p","method":"aes-256-gcm"}
+			]}`,
+			format:  "sip008",
+			hosts:   []string{"https://u:p@a.example.com:443"},
+			skipped: 1,
+		},
+		{
+			name: "clash yaml",
+			body: "port: 7890\nproxies:\n" +
+				"  - {name: a, type: http, server: a.example.com, port: 443, username: u, password: p, tls: true}\n" +
+				"  - {name: b, type: naive, server: b.example.com, port: \"8443\", username: u, password: p}\n" +
+				"  - {name: c, type: ss, server: c.example.com, port: 8388, password: p}\n",
+			format: "clash",
+			hosts: []string{
+				"https://u:p@a.example.com:443",
+				"https://u:p@b.example.com:8443",
+			},
+			skipped: 1,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			nodes, format, skipped, err := Parse([]byte(tc.body))
+			if err != nil {
+				t.Fatalf("Parse error: %v", err)
+			}
+			if format != tc.format {
+				t.Fatalf("format=%q expected=%q", format, tc.format)
+			}
+			if !reflect.DeepEqual(URLs(nodes), tc.hosts) {
+				t.Fatalf("hosts=%v expected=%v", URLs(nodes), tc.hosts)
+			}
+			if skipped != tc.skipped {
+				t.Fatalf("skipped=%d expected=%d", skipped, tc.skipped)
+			}
+		})
+	}
+}
+
+func TestParseUnknownFormat(t *testing.T) {
+	if _, _, _, err := Parse([]byte("<html>blocked</html>")); err == nil {
+		t.Fatal("expected error for unknown format")
+	}
+}
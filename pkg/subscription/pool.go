@@ -0,0 +1,270 @@
+package subscription
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"naiveswitcher/internal/config"
+	"naiveswitcher/pkg/log"
+)
+
+// SourceStatus 单个订阅源的抓取状态
+type SourceStatus struct {
+	Name        string    `json:"name"`
+	Enabled     bool      `json:"enabled"`
+	HostCount   int       `json:"host_count"`
+	Format      string    `json:"format"`
+	Skipped     int       `json:"skipped"`
+	LastFetch   time.Time `json:"last_fetch"`
+	LastSuccess time.Time `json:"last_success"`
+	LastError   string    `json:"last_error,omitempty"`
+	NotModified bool      `json:"not_modified"`      // 上一次抓取返回 304
+	FetchMode   string    `json:"fetch_mode"`        // 上一次成功抓取使用的方式
+	CacheAge    int64     `json:"cache_age_seconds"` // 上一次成功结果的时长（秒）
+	Stale       bool      `json:"stale"`             // 缓存已过期
+	UserInfo    *UserInfo `json:"userinfo,omitempty"`
+}
+
+type sourceState struct {
+	status     SourceStatus
+	nodes      []Node          // 最近一次成功抓取的结果
+	validators Validators      // 最近一次成功抓取的 ETag/Last-Modified
+	warned     map[string]bool // 已触发的警告，条件解除后重置
+}
+
+// Pool 管理多个订阅源，合并为一个服务器池
+// 单个订阅源失败时保留其上一次成功的结果
+// 成功的结果会缓存到 basePath 下，启动时可离线使用
+type Pool struct {
+	refreshMu sync.Mutex // 串行化 Refresh，避免并发刷新时订阅源列表被替换
+	mu        sync.RWMutex
+	sources   map[string]*sourceState
+	order     []string
+	basePath  string
+}
+
+// NewPool 创建订阅池
+func NewPool(basePath string) *Pool {
+	return &Pool{
+		sources:  make(map[string]*sourceState),
+		basePath: basePath,
+	}
+}
+
+// Refresh 并发抓取到期的订阅源并返回合并去重后的节点列表
+// force 为 true 时忽略刷新间隔；返回的错误汇总了失败的订阅源，此时列表仍可能可用
+// ctx 取消时停止等待重试
+func (p *Pool) Refresh(ctx context.Context, sources []config.SubscriptionSource, force bool) ([]Node, error) {
+	p.refreshMu.Lock()
+	defer p.refreshMu.Unlock()
+	p.sync(sources)
+
+	type result struct {
+		name  string
+		fetch FetchResult
+		err   error
+	}
+
+	now := time.Now()
+	results := make(chan result, len(sources))
+	wg := new(sync.WaitGroup)
+	for _, src := range sources {
+		if !src.IsEnabled() || !p.due(src, now, force) {
+			continue
+		}
+		wg.Add(1)
+		go func(src config.SubscriptionSource, prev Validators) {
+			defer wg.Done()
+			fetch, err := Subscription(ctx, src, prev)
+			results <- result{name: src.Name, fetch: fetch, err: err}
+		}(src, p.validators(src.Name))
+	}
+	wg.Wait()
+	close(results)
+
+	var errs []error
+	var updated bool
+	p.mu.Lock()
+	for res := range results {
+		st := p.sources[res.name]
+		if st == nil {
+			continue // 订阅源已被移除
+		}
+		st.status.LastFetch = now
+		if res.err != nil {
+			st.status.LastError = res.err.Error()
+			errs = append(errs, fmt.Errorf("%s: %w", res.name, res.err))
+			log.DebugF("Subscription %s fetch error: %v (keeping %d cached nodes)\n", res.name, res.err, len(st.nodes))
+			continue
+		}
+		updated = true
+		st.validators = res.fetch.Validators
+		st.status.LastError = ""
+		st.status.LastSuccess = now
+		st.status.NotModified = res.fetch.NotModified
+		st.status.FetchMode = res.fetch.Mode
+		if res.fetch.UserInfo != nil {
+			st.status.UserInfo = res.fetch.UserInfo
+		}
+		if res.fetch.NotModified {
+			log.DebugF("Subscription %s not modified via %s, reusing %d cached nodes\n", res.name, res.fetch.Mode, len(st.nodes))
+			continue
+		}
+		st.nodes = res.fetch.Nodes
+		st.status.HostCount = len(res.fetch.Nodes)
+		st.status.Format = res.fetch.Format
+		st.status.Skipped = res.fetch.Skipped
+		log.DebugF("Subscription %s fetched %d nodes via %s (format: %s, skipped: %d)\n",
+			res.name, len(res.fetch.Nodes), res.fetch.Mode, res.fetch.Format, res.fetch.Skipped)
+	}
+	if updated {
+		if err := p.saveCacheUnsafe(); err != nil {
+			log.DebugF("Save subscription cache error: %v\n", err)
+		}
+	}
+	p.mu.Unlock()
+
+	return p.Nodes(), errors.Join(errs...)
+}
+
+// Nodes 按订阅源顺序合并所有启用订阅源的节点，按 host 去重
+func (p *Pool) Nodes() []Node {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	seen := make(map[string]struct{})
+	var nodes []Node
+	for _, name := range p.order {
+		st := p.sources[name]
+		if !st.status.Enabled {
+			continue
+		}
+		for _, node := range st.nodes {
+			u, err := url.Parse(node.URL)
+			if err != nil {
+				continue
+			}
+			if _, dup := seen[u.Host]; dup {
+				continue
+			}
+			seen[u.Host] = struct{}{}
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// Status 返回各订阅源的抓取状态，上一次成功超过 staleAfter 的标记为过期
+func (p *Pool) Status(staleAfter time.Duration) []SourceStatus {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	now := time.Now()
+	statuses := make([]SourceStatus, 0, len(p.order))
+	for _, name := range p.order {
+		status := p.sources[name].status
+		if !status.LastSuccess.IsZero() {
+			age := now.Sub(status.LastSuccess)
+			status.CacheAge = int64(age.Seconds())
+			status.Stale = staleAfter > 0 && age > staleAfter
+		}
+		if status.UserInfo != nil {
+			info := status.UserInfo.withUsage(now)
+			status.UserInfo = &info
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// CheckWarnings 检查缓存过期、流量和到期时间，条件首次满足时记录警告事件
+func (p *Pool) CheckWarnings(opts config.Options) {
+	statuses := p.Status(opts.CacheStaleAfter())
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, status := range statuses {
+		st, ok := p.sources[status.Name]
+		if !ok || !status.Enabled {
+			continue
+		}
+		st.warn("stale", status.Stale, "subscription %s is stale, last success %s ago",
+			status.Name, time.Duration(status.CacheAge)*time.Second)
+
+		var quotaHit, expireHit bool
+		var used, days float64
+		if info := status.UserInfo; info != nil {
+			used, days = info.UsedPercent, info.DaysUntilExpiry
+			quotaHit = opts.QuotaWarnPercent > 0 && info.Total > 0 && used >= float64(opts.QuotaWarnPercent)
+			expireHit = opts.ExpireWarnDays > 0 && info.Expire > 0 && days <= float64(opts.ExpireWarnDays)
+		}
+		st.warn("quota", quotaHit, "subscription %s quota used %.1f%%", status.Name, used)
+		st.warn("expire", expireHit, "subscription %s expires in %.1f days", status.Name, days)
+	}
+}
+
+// warn 条件满足且尚未警告过时记录事件（需要外部已获取锁）
+func (st *sourceState) warn(kind string, active bool, format string, args ...interface{}) {
+	if !active {
+		delete(st.warned, kind)
+		return
+	}
+	if st.warned[kind] {
+		return
+	}
+	if st.warned == nil {
+		st.warned = make(map[string]bool)
+	}
+	st.warned[kind] = true
+	log.Warn("subscription_"+kind, format, args...)
+}
+
+// sync 根据当前配置增删订阅源，保留已有订阅源的状态
+func (p *Pool) sync(sources []config.SubscriptionSource) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	next := make(map[string]*sourceState, len(sources))
+	order := make([]string, 0, len(sources))
+	for _, src := range sources {
+		st, ok := p.sources[src.Name]
+		if !ok {
+			st = &sourceState{status: SourceStatus{Name: src.Name}}
+		}
+		st.status.Enabled = src.IsEnabled()
+		next[src.Name] = st
+		order = append(order, src.Name)
+	}
+	p.sources = next
+	p.order = order
+}
+
+// validators 返回订阅源的缓存校验信息，没有缓存节点时不发送条件请求
+func (p *Pool) validators(name string) Validators {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	st, ok := p.sources[name]
+	if !ok || len(st.nodes) == 0 {
+		return Validators{}
+	}
+	return st.validators
+}
+
+// due 判断订阅源是否需要刷新
+func (p *Pool) due(src config.SubscriptionSource, now time.Time, force bool) bool {
+	if force || src.RefreshInterval == 0 {
+		return true
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	st := p.sources[src.Name]
+	if st == nil {
+		return true
+	}
+	return st.status.LastSuccess.IsZero() ||
+		now.Sub(st.status.LastSuccess) >= time.Duration(src.RefreshInterval)*time.Minute
+}
@@ -0,0 +1,202 @@
+package subscription
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"naiveswitcher/internal/config"
+	"naiveswitcher/pkg/log"
+)
+
+func encodeSubscription(hosts ...string) string {
+	var lines []string
+	for _, h := range hosts {
+		lines = append(lines, "naive+https://"+base64.StdEncoding.EncodeToString([]byte(h)))
+	}
+	return base64.StdEncoding.EncodeToString([]byte(strings.Join(lines, "\n")))
+}
+
+func TestPoolMergeKeepsLastGood(t *testing.T) {
+	var failing atomic.Bool
+	a := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			http.Error(w, "down", http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(encodeSubscription("u:p@a.example.com:443", "u:p@shared.example.com:443")))
+	}))
+	defer a.Close()
+	b := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(encodeSubscription("u:p@shared.example.com:443", "u:p@b.example.com:443")))
+	}))
+	defer b.Close()
+
+	sources := []config.SubscriptionSource{
+		{Name: "a", URL: a.URL},
+		{Name: "b", URL: b.URL},
+	}
+	pool := NewPool(t.TempDir())
+
+	hosts, err := pool.Refresh(context.Background(), sources, true)
+	if err != nil {
+		t.Fatalf("Refresh error: %v", err)
+	}
+	if len(hosts) != 3 {
+		t.Fatalf("expected 3 de-duplicated hosts, got %v", hosts)
+	}
+
+	failing.Store(true)
+	hosts, err = pool.Refresh(context.Background(), sources, true)
+	if err == nil {
+		t.Fatal("expected error from failing source")
+	}
+	if len(hosts) != 3 {
+		t.Fatalf("expected last good hosts to be kept, got %v", hosts)
+	}
+	if status := pool.Status(0); status[0].LastError == "" || status[1].LastError != "" {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+}
+
+func TestPoolLoadCache(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(encodeSubscription("u:p@a.example.com:443")))
+	}))
+	sources := []config.SubscriptionSource{{Name: "a", URL: srv.URL}}
+	base := t.TempDir()
+
+	if _, err := NewPool(base).Refresh(context.Background(), sources, true); err != nil {
+		t.Fatalf("Refresh error: %v", err)
+	}
+	srv.Close()
+
+	pool := NewPool(base)
+	if err := pool.LoadCache(sources); err != nil {
+		t.Fatalf("LoadCache error: %v", err)
+	}
+	if nodes := pool.Nodes(); len(nodes) != 1 || nodes[0].URL != "https://u:p@a.example.com:443" {
+		t.Fatalf("unexpected cached nodes: %v", nodes)
+	}
+
+	// 离线时刷新失败，仍返回缓存结果
+	hosts, err := pool.Refresh(context.Background(), sources, true)
+	if err == nil || len(hosts) != 1 {
+		t.Fatalf("expected cached hosts with error, got %v, %v", hosts, err)
+	}
+}
+
+func TestPoolConditionalFetch(t *testing.T) {
+	var fetches, notModified atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("User-Agent") != "clash" || r.Header.Get("X-Token") != "t" {
+			http.Error(w, "bad headers", http.StatusForbidden)
+			return
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fetches.Add(1)
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(encodeSubscription("u:p@a.example.com:443")))
+	}))
+	defer srv.Close()
+
+	sources := []config.SubscriptionSource{{
+		Name:      "a",
+		URL:       srv.URL,
+		UserAgent: "clash",
+		Headers:   map[string]string{"X-Token": "t"},
+	}}
+	pool := NewPool(t.TempDir())
+	for i := 0; i < 2; i++ {
+		nodes, err := pool.Refresh(context.Background(), sources, true)
+		if err != nil {
+			t.Fatalf("Refresh error: %v", err)
+		}
+		if len(nodes) != 1 {
+			t.Fatalf("expected cached node to be reused, got %v", nodes)
+		}
+	}
+	if fetches.Load() != 1 || notModified.Load() != 1 {
+		t.Fatalf("expected 1 full fetch and 1 not modified, got %d/%d", fetches.Load(), notModified.Load())
+	}
+	if status := pool.Status(0); !status[0].NotModified || status[0].HostCount != 1 {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+}
+
+func TestPoolCheckWarningsOnce(t *testing.T) {
+	var download atomic.Int64
+	download.Store(950)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Subscription-Userinfo", "upload=0; download="+strconv.FormatInt(download.Load(), 10)+"; total=1000")
+		w.Write([]byte(encodeSubscription("u:p@a.example.com:443")))
+	}))
+	defer srv.Close()
+
+	opts := config.Options{QuotaWarnPercent: 90, Subscriptions: []config.SubscriptionSource{{Name: "quota-once", URL: srv.URL}}}
+	pool := NewPool(t.TempDir())
+	warnings := func() int {
+		n := 0
+		for _, e := range log.Events() {
+			if e.Kind == "subscription_quota" && strings.Contains(e.Message, "quota-once") {
+				n++
+			}
+		}
+		return n
+	}
+	refresh := func() {
+		t.Helper()
+		if _, err := pool.Refresh(context.Background(), opts.Sources(), true); err != nil {
+			t.Fatalf("Refresh error: %v", err)
+		}
+		pool.CheckWarnings(opts)
+	}
+
+	refresh()
+	refresh()
+	if n := warnings(); n != 1 {
+		t.Fatalf("quota warnings = %d, want 1 while over the threshold", n)
+	}
+
+	// 回落到阈值以下后再次超过时重新警告
+	download.Store(100)
+	refresh()
+	download.Store(950)
+	refresh()
+	if n := warnings(); n != 2 {
+		t.Fatalf("quota warnings = %d, want 2 after crossing the threshold again", n)
+	}
+}
+
+func TestSubscriptionRetryCancelled(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	src := config.SubscriptionSource{Name: "a", URL: srv.URL, Retries: 10, RetryBackoff: 3600}
+	start := time.Now()
+	if _, err := Subscription(ctx, src, Validators{}); err == nil {
+		t.Fatal("expected error")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("retry not cancelled, took %s", elapsed)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Fatalf("requests = %d, want 1", n)
+	}
+}
@@ -0,0 +1,149 @@
+package subscription
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+)
+
+// 测速参数
+const (
+	probeTimeout   = 5 * time.Second
+	probeSizeKB    = 1024  // /1Mb.dat 的大小
+	failurePenalty = 500.0 // 每单位故障分折算的毫秒数
+)
+
+// ProbeResult 单个节点的测速结果
+type ProbeResult struct {
+	URL        string  `json:"url"`
+	Host       string  `json:"host"`
+	OK         bool    `json:"ok"`
+	ConnectMs  float64 `json:"connect_ms"`      // TCP 连接耗时
+	TLSMs      float64 `json:"tls_ms"`          // TLS 握手耗时
+	TTFBMs     float64 `json:"ttfb_ms"`         // 从发起请求到首字节，包含连接和握手
+	Throughput float64 `json:"throughput_kbps"` // 下载速度（KB/s）
+	Failures   float64 `json:"failures"`        // 衰减后的故障分减去优先级加成
+	Score      float64 `json:"score"`           // 越小越好，失败的节点为 0
+	Error      string  `json:"error,omitempty"`
+}
+
+// score 综合延迟、吞吐量和故障历史计算评分（毫秒），越小越好
+// 以首字节时间加下载 1MB 的预计耗时为基础，每单位故障分增加 failurePenalty
+func score(r ProbeResult) float64 {
+	s := r.TTFBMs
+	if r.Throughput > 0 {
+		s += probeSizeKB / r.Throughput * 1000
+	}
+	return s + r.Failures*failurePenalty
+}
+
+// rank 按评分排序，失败的节点排在最后
+func rank(results []ProbeResult) {
+	slices.SortStableFunc(results, func(a, b ProbeResult) int {
+		if a.OK != b.OK {
+			if a.OK {
+				return -1
+			}
+			return 1
+		}
+		switch {
+		case a.Score < b.Score:
+			return -1
+		case a.Score > b.Score:
+			return 1
+		}
+		return 0
+	})
+}
+
+// probeClient 每次测速使用独立的连接，保证能测到连接和握手耗时
+func probeClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DisableKeepAlives = true
+	return &http.Client{Transport: transport}
+}
+
+// probe 下载 /1Mb.dat 并记录各阶段耗时
+func probe(ctx context.Context, client *http.Client, hostUrl string) ProbeResult {
+	r := ProbeResult{URL: hostUrl}
+	u, err := url.Parse(hostUrl)
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	r.Host = u.Hostname()
+
+	// 双栈拨号时回调可能并发触发
+	var mu sync.Mutex
+	var connectStart, tlsStart time.Time
+	trace := &httptrace.ClientTrace{
+		ConnectStart: func(string, string) {
+			mu.Lock()
+			connectStart = time.Now()
+			mu.Unlock()
+		},
+		ConnectDone: func(_, _ string, err error) {
+			mu.Lock()
+			if err == nil && r.ConnectMs == 0 {
+				r.ConnectMs = millis(time.Since(connectStart))
+			}
+			mu.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			mu.Lock()
+			tlsStart = time.Now()
+			mu.Unlock()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			mu.Lock()
+			r.TLSMs = millis(time.Since(tlsStart))
+			mu.Unlock()
+		},
+	}
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s://%s/1Mb.dat", probeScheme(u.Scheme), u.Host), nil)
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	req = req.WithContext(httptrace.WithClientTrace(ctx, trace))
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	defer resp.Body.Close()
+	firstByte := time.Now()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	if len(body) < 1024 {
+		r.Error = fmt.Sprintf("invalid response, status code: %d, body: %s", resp.StatusCode, string(body))
+		return r
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	r.TTFBMs = millis(firstByte.Sub(start))
+	if elapsed := time.Since(firstByte).Seconds(); elapsed > 0 {
+		r.Throughput = float64(len(body)) / 1024 / elapsed
+	}
+	r.OK = true
+	return r
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,80 @@
+package subscription
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRankCombinesLatencyThroughputAndFailures(t *testing.T) {
+	results := []ProbeResult{
+		{URL: "failed"},
+		{URL: "slow", OK: true, TTFBMs: 300, Throughput: 2048},
+		{URL: "fast", OK: true, TTFBMs: 50, Throughput: 4096},
+		{URL: "flaky", OK: true, TTFBMs: 50, Throughput: 4096, Failures: 2},
+	}
+	for i := range results {
+		if results[i].OK {
+			results[i].Score = score(results[i])
+		}
+	}
+	rank(results)
+	var order []string
+	for _, r := range results {
+		order = append(order, r.URL)
+	}
+	want := []string{"fast", "slow", "flaky", "failed"}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("expected order %v, got %v", want, order)
+		}
+	}
+}
+
+func probeServer(t *testing.T, ip string, delay time.Duration) *httptest.Server {
+	t.Helper()
+	l, err := net.Listen("tcp", ip+":0")
+	if err != nil {
+		t.Skipf("listen on %s: %v", ip, err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		w.Write(bytes.Repeat([]byte("x"), 64*1024))
+	}))
+	srv.Listener.Close()
+	srv.Listener = l
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestFastestReturnsMeasurements(t *testing.T) {
+	fast := probeServer(t, "127.0.0.1", 0)
+	slow := probeServer(t, "127.0.0.2", 300*time.Millisecond)
+
+	best, results, err := Fastest([]string{fast.URL, slow.URL}, map[string]float64{}, nil, "")
+	if err != nil {
+		t.Fatalf("Fastest error: %v", err)
+	}
+	if best != fast.URL {
+		t.Fatalf("expected %s to win, got %s (%+v)", fast.URL, best, results)
+	}
+	if len(results) != 2 || !results[0].OK || !results[1].OK {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	if results[1].TTFBMs < 300 || results[0].Throughput <= 0 {
+		t.Fatalf("measurements not recorded: %+v", results)
+	}
+
+	// 故障分足够高时慢节点胜出
+	best, _, err = Fastest([]string{fast.URL, slow.URL}, map[string]float64{"127.0.0.1": 5}, nil, "")
+	if err != nil {
+		t.Fatalf("Fastest error: %v", err)
+	}
+	if best != slow.URL {
+		t.Fatalf("expected failure history to demote %s, got %s", fast.URL, best)
+	}
+}
@@ -0,0 +1,165 @@
+package subscription
+
+import (
+	"fmt"
+	"math/rand"
+	"net/url"
+	"slices"
+	"strings"
+
+	"naiveswitcher/internal/config"
+)
+
+// Selector 根据测速结果选择下一个服务器
+type Selector interface {
+	// Name 策略名称
+	Name() string
+	// Select 从可用的测速结果中选择节点，current 为当前使用的节点
+	Select(results []ProbeResult, current string) (string, error)
+}
+
+// NewSelector 按名称创建选择策略，failover 为 failover 策略的优先级列表（节点 URL 或主机名）
+func NewSelector(name string, failover []string) (Selector, error) {
+	switch name {
+	case "", config.SelectorScore:
+		return scoreSelector{}, nil
+	case config.SelectorLowestLatency:
+		return lowestLatencySelector{}, nil
+	case config.SelectorHighestThroughput:
+		return highestThroughputSelector{}, nil
+	case config.SelectorRoundRobin:
+		return roundRobinSelector{}, nil
+	case config.SelectorWeightedRandom:
+		return weightedRandomSelector{}, nil
+	case config.SelectorFailover:
+		return failoverSelector{order: failover}, nil
+	}
+	return nil, fmt.Errorf("unknown selector: %s", name)
+}
+
+// Select 去掉失败的节点和故障节点后交给策略选择
+// 故障节点仅在没有其他可用节点时参与选择
+func Select(sel Selector, results []ProbeResult, current string, deadServer string) (string, error) {
+	var deadHost string
+	if deadServer != "" {
+		if u, err := url.Parse(deadServer); err == nil {
+			deadHost = u.Hostname()
+		}
+	}
+
+	var alive, dead []ProbeResult
+	for _, r := range results {
+		switch {
+		case !r.OK:
+		case deadHost != "" && r.Host == deadHost:
+			dead = append(dead, r)
+		default:
+			alive = append(alive, r)
+		}
+	}
+	if len(alive) == 0 {
+		alive = dead
+	}
+	if len(alive) == 0 {
+		return "", fmt.Errorf("no valid hosts found")
+	}
+	return sel.Select(alive, current)
+}
+
+// bestBy 返回 less 意义下最小的节点，相同时保留靠前的
+func bestBy(results []ProbeResult, less func(a, b ProbeResult) bool) string {
+	best := results[0]
+	for _, r := range results[1:] {
+		if less(r, best) {
+			best = r
+		}
+	}
+	return best.URL
+}
+
+type scoreSelector struct{}
+
+func (scoreSelector) Name() string { return config.SelectorScore }
+
+func (scoreSelector) Select(results []ProbeResult, _ string) (string, error) {
+	return bestBy(results, func(a, b ProbeResult) bool { return a.Score < b.Score }), nil
+}
+
+// lowestLatencySelector 故障分折算为延迟惩罚
+type lowestLatencySelector struct{}
+
+func (lowestLatencySelector) Name() string { return config.SelectorLowestLatency }
+
+func (lowestLatencySelector) Select(results []ProbeResult, _ string) (string, error) {
+	latency := func(r ProbeResult) float64 { return r.TTFBMs + r.Failures*failurePenalty }
+	return bestBy(results, func(a, b ProbeResult) bool { return latency(a) < latency(b) }), nil
+}
+
+// highestThroughputSelector 故障分越高，速度打折越多
+type highestThroughputSelector struct{}
+
+func (highestThroughputSelector) Name() string { return config.SelectorHighestThroughput }
+
+func (highestThroughputSelector) Select(results []ProbeResult, _ string) (string, error) {
+	throughput := func(r ProbeResult) float64 { return r.Throughput / (1 + max(r.Failures, 0)) }
+	return bestBy(results, func(a, b ProbeResult) bool { return throughput(a) > throughput(b) }), nil
+}
+
+// roundRobinSelector 按 URL 排序后选择当前节点的下一个
+type roundRobinSelector struct{}
+
+func (roundRobinSelector) Name() string { return config.SelectorRoundRobin }
+
+func (roundRobinSelector) Select(results []ProbeResult, current string) (string, error) {
+	urls := make([]string, 0, len(results))
+	for _, r := range results {
+		urls = append(urls, r.URL)
+	}
+	slices.Sort(urls)
+	for _, u := range urls {
+		if u > current {
+			return u, nil
+		}
+	}
+	return urls[0], nil
+}
+
+// weightedRandomSelector 权重与评分成反比
+type weightedRandomSelector struct{}
+
+func (weightedRandomSelector) Name() string { return config.SelectorWeightedRandom }
+
+func (weightedRandomSelector) Select(results []ProbeResult, _ string) (string, error) {
+	weights := make([]float64, len(results))
+	var total float64
+	for i, r := range results {
+		weights[i] = 1 / max(r.Score, 1)
+		total += weights[i]
+	}
+	n := rand.Float64() * total
+	for i, w := range weights {
+		if n < w {
+			return results[i].URL, nil
+		}
+		n -= w
+	}
+	return results[len(results)-1].URL, nil
+}
+
+// failoverSelector 按优先级列表选择第一个可用节点，列表中的节点都不可用时按评分选择
+type failoverSelector struct {
+	order []string
+}
+
+func (failoverSelector) Name() string { return config.SelectorFailover }
+
+func (s failoverSelector) Select(results []ProbeResult, current string) (string, error) {
+	for _, want := range s.order {
+		for _, r := range results {
+			if r.URL == want || strings.EqualFold(r.Host, want) {
+				return r.URL, nil
+			}
+		}
+	}
+	return scoreSelector{}.Select(results, current)
+}
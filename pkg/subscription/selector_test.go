@@ -0,0 +1,64 @@
+package subscription
+
+import (
+	"testing"
+
+	"naiveswitcher/internal/config"
+)
+
+func selectorResults() []ProbeResult {
+	return []ProbeResult{
+		{URL: "https://a.example.com", Host: "a.example.com", OK: true, TTFBMs: 50, Throughput: 1000, Score: 1050},
+		{URL: "https://b.example.com", Host: "b.example.com", OK: true, TTFBMs: 200, Throughput: 8000, Score: 328},
+		{URL: "https://c.example.com", Host: "c.example.com", OK: true, TTFBMs: 100, Throughput: 2000, Score: 612},
+		{URL: "https://d.example.com", Host: "d.example.com"},
+	}
+}
+
+func TestSelectors(t *testing.T) {
+	tests := []struct {
+		name     string
+		failover []string
+		current  string
+		dead     string
+		want     string
+	}{
+		{name: config.SelectorScore, want: "https://b.example.com"},
+		{name: config.SelectorScore, dead: "https://b.example.com", want: "https://c.example.com"},
+		{name: config.SelectorLowestLatency, want: "https://a.example.com"},
+		{name: config.SelectorHighestThroughput, want: "https://b.example.com"},
+		{name: config.SelectorRoundRobin, current: "https://a.example.com", want: "https://b.example.com"},
+		{name: config.SelectorRoundRobin, current: "https://c.example.com", want: "https://a.example.com"},
+		{name: config.SelectorFailover, failover: []string{"https://d.example.com", "c.example.com"}, want: "https://c.example.com"},
+		{name: config.SelectorFailover, failover: []string{"x.example.com"}, want: "https://b.example.com"},
+	}
+	for _, tt := range tests {
+		sel, err := NewSelector(tt.name, tt.failover)
+		if err != nil {
+			t.Fatalf("NewSelector(%s) error: %v", tt.name, err)
+		}
+		got, err := Select(sel, selectorResults(), tt.current, tt.dead)
+		if err != nil {
+			t.Fatalf("%s: Select error: %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Fatalf("%s (current %q, dead %q): expected %s, got %s", tt.name, tt.current, tt.dead, tt.want, got)
+		}
+	}
+}
+
+func TestSelectWeightedRandomSkipsFailed(t *testing.T) {
+	sel, _ := NewSelector(config.SelectorWeightedRandom, nil)
+	for i := 0; i < 50; i++ {
+		got, err := Select(sel, selectorResults(), "", "")
+		if err != nil {
+			t.Fatalf("Select error: %v", err)
+		}
+		if got == "https://d.example.com" {
+			t.Fatal("failed probe must not be selected")
+		}
+	}
+	if _, err := NewSelector("unknown", nil); err == nil {
+		t.Fatal("expected error for unknown selector")
+	}
+}
@@ -0,0 +1,183 @@
+package subscription
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"naiveswitcher/internal/config"
+	"naiveswitcher/util"
+)
+
+const staticNodesFile = "static_nodes.json"
+
+// 静态节点来源
+const (
+	OriginConfig = "config" // 配置文件，只读
+	OriginAPI    = "api"    // 通过 API 添加，持久化到磁盘
+)
+
+// StaticEntry 静态节点及其来源
+type StaticEntry struct {
+	config.StaticNode
+	Origin string `json:"origin"`
+}
+
+// StaticStore 管理通过 API 编辑的静态节点，配置文件中的静态节点只读
+type StaticStore struct {
+	mu       sync.RWMutex
+	nodes    []config.StaticNode
+	basePath string
+}
+
+// NewStaticStore 创建静态节点存储并加载磁盘上的节点
+func NewStaticStore(basePath string) (*StaticStore, error) {
+	s := &StaticStore{basePath: basePath}
+	data, err := os.ReadFile(s.path())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return s, err
+	}
+	if err := json.Unmarshal(data, &s.nodes); err != nil {
+		return s, err
+	}
+	return s, nil
+}
+
+func (s *StaticStore) path() string {
+	return filepath.Join(s.basePath, staticNodesFile)
+}
+
+// List 返回所有静态节点，配置文件中的节点在前
+func (s *StaticStore) List(configNodes []config.StaticNode) []StaticEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := make([]StaticEntry, 0, len(configNodes)+len(s.nodes))
+	for _, n := range configNodes {
+		entries = append(entries, StaticEntry{StaticNode: n, Origin: OriginConfig})
+	}
+	for _, n := range s.nodes {
+		entries = append(entries, StaticEntry{StaticNode: n, Origin: OriginAPI})
+	}
+	return entries
+}
+
+// Add 添加节点，名称不能与已有静态节点重复
+func (s *StaticStore) Add(configNodes []config.StaticNode, node config.StaticNode) error {
+	if err := node.Validate(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.indexUnsafe(node.Name) >= 0 || configIndex(configNodes, node.Name) >= 0 {
+		return fmt.Errorf("node %q already exists", node.Name)
+	}
+	s.nodes = append(s.nodes, node)
+	return s.saveUnsafe()
+}
+
+// Update 按名称更新通过 API 添加的节点
+func (s *StaticStore) Update(configNodes []config.StaticNode, node config.StaticNode) error {
+	if err := node.Validate(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.indexUnsafe(node.Name)
+	if i < 0 {
+		if configIndex(configNodes, node.Name) >= 0 {
+			return errors.New("node is defined in config file and cannot be edited")
+		}
+		return fmt.Errorf("node %q not found", node.Name)
+	}
+	s.nodes[i] = node
+	return s.saveUnsafe()
+}
+
+// Delete 按名称删除通过 API 添加的节点
+func (s *StaticStore) Delete(configNodes []config.StaticNode, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.indexUnsafe(name)
+	if i < 0 {
+		if configIndex(configNodes, name) >= 0 {
+			return errors.New("node is defined in config file and cannot be deleted")
+		}
+		return fmt.Errorf("node %q not found", name)
+	}
+	s.nodes = append(s.nodes[:i], s.nodes[i+1:]...)
+	return s.saveUnsafe()
+}
+
+// indexUnsafe 按名称查找节点（需要外部已获取锁）
+func (s *StaticStore) indexUnsafe(name string) int {
+	for i, n := range s.nodes {
+		if n.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// saveUnsafe 原子地写入磁盘（需要外部已获取锁）
+func (s *StaticStore) saveUnsafe() error {
+	data, err := json.Marshal(s.nodes)
+	if err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(s.path(), data, 0o600)
+}
+
+func configIndex(nodes []config.StaticNode, name string) int {
+	for i, n := range nodes {
+		if n.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// MergeStatic 将静态节点合并到订阅节点前面，按 host 去重（静态节点优先）
+func MergeStatic(nodes []Node, static []StaticEntry) []Node {
+	merged := make([]Node, 0, len(static)+len(nodes))
+	seen := make(map[string]struct{})
+	add := func(n Node) {
+		u, err := url.Parse(n.URL)
+		if err != nil {
+			return
+		}
+		if _, dup := seen[u.Host]; dup {
+			return
+		}
+		seen[u.Host] = struct{}{}
+		merged = append(merged, n)
+	}
+	for _, e := range static {
+		add(Node{Name: e.Name, URL: e.URL})
+	}
+	for _, n := range nodes {
+		add(n)
+	}
+	return merged
+}
+
+// PriorityBonus 返回静态节点按主机名的优先级加成
+func PriorityBonus(static []StaticEntry) map[string]int {
+	bonus := make(map[string]int)
+	for _, e := range static {
+		if e.Priority == 0 {
+			continue
+		}
+		if u, err := url.Parse(e.URL); err == nil {
+			bonus[u.Hostname()] = e.Priority
+		}
+	}
+	return bonus
+}
@@ -0,0 +1,43 @@
+package subscription
+
+import (
+	"testing"
+
+	"naiveswitcher/internal/config"
+)
+
+func TestStaticStorePersist(t *testing.T) {
+	base := t.TempDir()
+	configNodes := []config.StaticNode{{Name: "cfg", URL: "https://u:p@cfg.example.com:443"}}
+
+	store, err := NewStaticStore(base)
+	if err != nil {
+		t.Fatalf("NewStaticStore error: %v", err)
+	}
+	if err := store.Add(configNodes, config.StaticNode{Name: "home", URL: "https://u:p@home.example.com:443", Priority: 5}); err != nil {
+		t.Fatalf("Add error: %v", err)
+	}
+	if err := store.Add(configNodes, config.StaticNode{Name: "cfg", URL: "https://u:p@other.example.com:443"}); err == nil {
+		t.Fatal("expected duplicate name error")
+	}
+	if err := store.Delete(configNodes, "cfg"); err == nil {
+		t.Fatal("expected error deleting config node")
+	}
+
+	reloaded, err := NewStaticStore(base)
+	if err != nil {
+		t.Fatalf("reload error: %v", err)
+	}
+	entries := reloaded.List(configNodes)
+	if len(entries) != 2 || entries[0].Origin != OriginConfig || entries[1].Name != "home" || entries[1].Origin != OriginAPI {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+
+	merged := MergeStatic([]Node{{Name: "sub", URL: "https://x:y@home.example.com:443"}}, entries)
+	if len(merged) != 2 || merged[1].Name != "home" {
+		t.Fatalf("static node should win de-duplication: %+v", merged)
+	}
+	if bonus := PriorityBonus(entries); bonus["home.example.com"] != 5 {
+		t.Fatalf("unexpected bonus: %v", bonus)
+	}
+}
@@ -1,10 +1,8 @@
 package subscription
 
 import (
-	"bufio"
-	"bytes"
 	"context"
-	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
@@ -14,57 +12,214 @@ import (
 	"sync"
 	"time"
 
+	"naiveswitcher/internal/config"
+	"naiveswitcher/pkg/common"
 	"naiveswitcher/pkg/log"
 	"naiveswitcher/util"
 )
 
-func Subscription(subscribeURL string) ([]string, error) {
-	var hostUrls []string
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// Validators 条件请求使用的缓存校验信息
+type Validators struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// FetchResult 订阅抓取结果
+type FetchResult struct {
+	Nodes       []Node
+	Format      string // 识别出的订阅格式
+	Skipped     int    // 无法解析而跳过的条目数
+	UserInfo    *UserInfo
+	Validators  Validators
+	NotModified bool   // 服务端返回 304，沿用缓存的节点
+	Mode        string // 实际使用的抓取方式：direct 或 tunnel
+}
+
+// 订阅抓取的默认参数
+const (
+	defaultFetchTimeout = 5 * time.Second
+	defaultRetryBackoff = time.Second
+	maxRetryBackoff     = 30 * time.Second // 重试间隔的上限
+	maxRetryDuration    = 2 * time.Minute  // 单个订阅源所有重试的总时长上限
+)
+
+// retryableError 可重试的错误（网络错误或 5xx）
+type retryableError struct {
+	err error
+}
+
+func (e retryableError) Error() string { return e.err.Error() }
+func (e retryableError) Unwrap() error { return e.err }
+
+// Subscription 抓取订阅源，prev 非空时发送条件请求
+// 网络错误和 5xx 按 retries 重试，间隔指数退避，最长 maxRetryBackoff，总时长不超过 maxRetryDuration
+// auto 模式下每次尝试先直连，失败后经由 naive 上游；ctx 取消时立即返回
+func Subscription(ctx context.Context, src config.SubscriptionSource, prev Validators) (FetchResult, error) {
+	backoff := min(time.Duration(src.RetryBackoff)*time.Second, maxRetryBackoff)
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+	deadline := time.Now().Add(maxRetryDuration)
+
+	var modes []string
+	switch src.Mode() {
+	case config.FetchModeTunnel:
+		modes = []string{config.FetchModeTunnel}
+	case config.FetchModeAuto:
+		modes = []string{config.FetchModeDirect, config.FetchModeTunnel}
+	default:
+		modes = []string{config.FetchModeDirect}
+	}
+
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		var errs []error
+		for _, mode := range modes {
+			res, err := fetchOnce(ctx, src, prev, mode)
+			if err == nil {
+				res.Mode = mode
+				return res, nil
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", mode, err))
+			var retryable retryableError
+			retry = retry || errors.As(err, &retryable)
+		}
+		err := errors.Join(errs...)
+		if len(errs) == 1 {
+			err = errors.Unwrap(errs[0])
+		}
+		if !retry || attempt >= src.Retries || time.Now().Add(backoff).After(deadline) {
+			return FetchResult{}, err
+		}
+		log.DebugF("Subscription %s fetch error: %v, retry in %s\n", src.Name, err, backoff)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return FetchResult{}, errors.Join(err, ctx.Err())
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, maxRetryBackoff)
+	}
+}
+
+var (
+	tunnelClientOnce sync.Once
+	tunnelClient     *http.Client
+)
+
+// client 返回对应抓取方式的 HTTP 客户端，tunnel 经由 naive 的 SOCKS 上游
+func client(mode string) *http.Client {
+	if mode != config.FetchModeTunnel {
+		return http.DefaultClient
+	}
+	tunnelClientOnce.Do(func() {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		// 每次请求读取当前 naive 进程的地址，切换后端口会变化
+		transport.Proxy = func(*http.Request) (*url.URL, error) {
+			addr := common.UpstreamAddr()
+			if addr == "" {
+				return nil, errors.New("naive is not running")
+			}
+			return &url.URL{Scheme: "socks5", Host: addr}, nil
+		}
+		tunnelClient = &http.Client{Transport: transport}
+	})
+	return tunnelClient
+}
+
+func fetchOnce(ctx context.Context, src config.SubscriptionSource, prev Validators, mode string) (FetchResult, error) {
+	timeout := time.Duration(src.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = defaultFetchTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	req, err := http.NewRequest("GET", subscribeURL, nil)
+	req, err := http.NewRequest("GET", src.URL, nil)
 	if err != nil {
-		return nil, err
+		return FetchResult{}, err
 	}
 	req = req.WithContext(ctx)
-	resp, err := http.DefaultClient.Do(req)
+	for k, v := range src.Headers {
+		req.Header.Set(k, v)
+	}
+	if src.UserAgent != "" {
+		req.Header.Set("User-Agent", src.UserAgent)
+	}
+	if prev.ETag != "" {
+		req.Header.Set("If-None-Match", prev.ETag)
+	}
+	if prev.LastModified != "" {
+		req.Header.Set("If-Modified-Since", prev.LastModified)
+	}
+
+	resp, err := client(mode).Do(req)
 	if err != nil {
-		return nil, err
+		return FetchResult{}, retryableError{err}
 	}
+	defer resp.Body.Close()
 
-	userInfo := resp.Header.Get("Subscription-Userinfo")
-	log.DebugF("Userinfo: %s\n", userInfo)
+	userInfo, hasUserInfo := ParseUserInfo(resp.Header.Get("Subscription-Userinfo"))
+	if hasUserInfo {
+		log.DebugF("Userinfo: %+v\n", *userInfo)
+	}
+	validators := Validators{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		if validators == (Validators{}) {
+			validators = prev
+		}
+		return FetchResult{UserInfo: userInfo, Validators: validators, NotModified: true}, nil
+	case resp.StatusCode >= 500:
+		return FetchResult{}, retryableError{fmt.Errorf("unexpected status code: %d", resp.StatusCode)}
+	case resp.StatusCode != http.StatusOK:
+		return FetchResult{}, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
 
-	defer resp.Body.Close()
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return FetchResult{}, retryableError{err}
 	}
 
-	bodyDecoded, err := base64.StdEncoding.DecodeString(string(body))
+	nodes, format, skipped, err := Parse(body)
 	if err != nil {
-		return nil, err
+		return FetchResult{}, err
 	}
-
-	scanner := bufio.NewScanner(bytes.NewBuffer(bodyDecoded))
-	for scanner.Scan() {
-		line := scanner.Text()
-		u, err := url.Parse(line)
-		if err != nil {
-			return nil, err
-		}
-		hostDecoded, err := base64.StdEncoding.DecodeString(u.Host)
-		if err != nil {
-			return nil, err
-		}
-		hostUrls = append(hostUrls, "https://"+string(hostDecoded))
+	if skipped > 0 {
+		log.DebugF("Subscription skipped %d unparseable entries (format: %s)\n", skipped, format)
 	}
+	if len(nodes) == 0 {
+		return FetchResult{}, fmt.Errorf("no valid servers in subscription (format: %s, skipped: %d)", format, skipped)
+	}
+
+	return FetchResult{
+		Nodes:      nodes,
+		Format:     format,
+		Skipped:    skipped,
+		UserInfo:   userInfo,
+		Validators: validators,
+	}, nil
+}
 
-	return hostUrls, nil
+// Fastest 测速后按综合评分选择最佳节点，返回每个节点的测速结果（按评分排序）
+func Fastest(hostUrls []string, penalty map[string]float64, bonus map[string]int, deadServer string) (string, []ProbeResult, error) {
+	results, err := Probe(hostUrls, penalty, bonus, deadServer, "")
+	if err != nil {
+		return "", results, err
+	}
+	best, err := Select(scoreSelector{}, results, "", deadServer)
+	return best, results, err
 }
 
-func Fastest(hostUrls []string, serverPriority map[string]int, deadServer string) (string, error) {
+// Probe 对候选节点测速并按评分排序（同一 IP 只测一个节点）
+// penalty 为按主机名的故障分，bonus 为优先级加成，抵消等量的故障分
+// current 为正在使用的节点，同一 IP 的节点中总是测它，保证迟滞判断有当前节点的数据
+func Probe(hostUrls []string, penalty map[string]float64, bonus map[string]int, deadServer string, current string) ([]ProbeResult, error) {
 	hostIps := util.BatchLookupURLsIP(hostUrls)
 	ipHostMap := make(map[string][]util.HostIps)
 	for _, ips := range hostIps {
@@ -75,27 +230,20 @@ func Fastest(hostUrls []string, serverPriority map[string]int, deadServer string
 	}
 
 	if len(ipHostMap) == 0 {
-		return "", fmt.Errorf("no hosts")
+		return nil, fmt.Errorf("no hosts")
 	}
 
-	type result struct {
-		host *url.URL
-		err  error
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
 	defer cancel()
+	client := probeClient()
 
-	results := make(chan result, len(ipHostMap))
-	closeLock := new(sync.Mutex)
-	var closed bool
-
-	aliveHosts := new(sync.Map)
-
+	probes := make(chan ProbeResult, len(ipHostMap))
 	for _, hosts := range ipHostMap {
 		var u string
 		if len(hosts) == 1 {
 			u = hosts[0].URL
+		} else if current != "" && current != deadServer && slices.ContainsFunc(hosts, func(h util.HostIps) bool { return h.URL == current }) {
+			u = current
 		} else {
 		RE_RAND:
 			u = hosts[rand.Intn(len(hosts))].URL
@@ -104,106 +252,33 @@ func Fastest(hostUrls []string, serverPriority map[string]int, deadServer string
 			}
 		}
 		go func(host string) {
-			var finalError error
-			var proxyUrl *url.URL
-
-			defer func() {
-				closeLock.Lock()
-				if !closed {
-					results <- result{host: proxyUrl, err: finalError}
-				}
-				closeLock.Unlock()
-			}()
-
-			proxyUrl, finalError = url.Parse(host)
-			if finalError != nil {
-				return
-			}
-
-			aliveHosts.Store(proxyUrl.Hostname(), struct{}{})
-
-			req, err := http.NewRequest("GET", fmt.Sprintf("%s://%s/1Mb.dat", proxyUrl.Scheme, proxyUrl.Host), nil)
-			if err != nil {
-				finalError = err
-				return
-			}
-			req = req.WithContext(ctx)
-
-			resp, err := http.DefaultClient.Do(req)
-			if err != nil {
-				finalError = err
-				return
-			}
-			defer resp.Body.Close()
-
-			body, err := io.ReadAll(resp.Body)
-			if err != nil {
-				finalError = err
-				return
-			}
-
-			if len(body) < 1024 {
-				finalError = fmt.Errorf("invalid response, status code: %d, body: %s", resp.StatusCode, string(body))
-				return
-			}
+			probes <- probe(ctx, client, host)
 		}(u)
 	}
 
-	var fastest []*url.URL
-	var resultCount int
-	for res := range results {
-		resultCount++
-		if res.err != nil {
-			log.DebugF("check activity failed, host: %s, error: %s\n", res.host, res.err)
+	results := make([]ProbeResult, 0, len(ipHostMap))
+	for range ipHostMap {
+		res := <-probes
+		if res.OK {
+			res.Failures = penalty[res.Host] - float64(bonus[res.Host])
+			res.Score = score(res)
 		} else {
-			fastest = append(fastest, res.host)
-		}
-		if len(fastest) > 2 || resultCount >= len(ipHostMap) {
-			break
+			log.DebugF("check activity failed, host: %s, error: %s\n", res.URL, res.Error)
 		}
+		results = append(results, res)
 	}
+	rank(results)
 
-	closeLock.Lock()
-	closed = true
-	close(results)
-	closeLock.Unlock()
-
-	if len(fastest) == 0 {
-		return "", fmt.Errorf("no valid hosts found")
-	}
-
-	slices.SortFunc(fastest, func(a, b *url.URL) int {
-		return serverPriority[a.Hostname()] - serverPriority[b.Hostname()]
-	})
-
-	var minCount int
-	for k, v := range serverPriority {
-		if _, has := hostIps[k]; !has {
-			delete(serverPriority, k)
-		}
-		if minCount == 0 || v < minCount {
-			minCount = v
-		}
-	}
-	if minCount > 100 {
-		for k := range serverPriority {
-			if serverPriority[k] <= minCount {
-				delete(serverPriority, k)
-				continue
-			}
-			serverPriority[k] -= minCount
-		}
+	if !results[0].OK {
+		return results, fmt.Errorf("no valid hosts found")
 	}
+	return results, nil
+}
 
-	if deadServer == "" || len(fastest) == 1 {
-		return fastest[0].String(), nil
-	}
-	deadServerUrl, err := url.Parse(deadServer)
-	if err != nil {
-		return fastest[0].String(), nil
-	}
-	if deadServerUrl.Hostname() == fastest[0].Hostname() {
-		return fastest[1].String(), nil
+// probeScheme quic 节点使用同端口的 https 进行探测
+func probeScheme(scheme string) string {
+	if scheme == "quic" {
+		return "https"
 	}
-	return fastest[0].String(), nil
+	return scheme
 }
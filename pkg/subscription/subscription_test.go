@@ -0,0 +1,59 @@
+package subscription
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"naiveswitcher/internal/config"
+	"naiveswitcher/internal/sockstest"
+	"naiveswitcher/pkg/common"
+)
+
+func TestSubscriptionTunnelFallback(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(encodeSubscription("u:p@a.example.com:443")))
+	}))
+	defer srv.Close()
+	// 模拟 naive 上游，无论目标地址都转发到订阅服务
+	upstream := sockstest.Serve(t, func(c net.Conn, target string) {
+		sockstest.Relay(c, srv.Listener.Addr().String())
+	})
+	setUpstreamAddr(t, upstream)
+
+	// 直连地址不可达，只能经由上游抓取
+	for _, mode := range []string{config.FetchModeAuto, config.FetchModeTunnel} {
+		src := config.SubscriptionSource{Name: mode, URL: "http://127.0.0.1:1/sub", FetchMode: mode}
+		res, err := Subscription(context.Background(), src, Validators{})
+		if err != nil {
+			t.Fatalf("%s: Subscription error: %v", mode, err)
+		}
+		if res.Mode != config.FetchModeTunnel {
+			t.Fatalf("%s: fetch mode = %s, want %s", mode, res.Mode, config.FetchModeTunnel)
+		}
+	}
+
+	src := config.SubscriptionSource{Name: "direct", URL: "http://127.0.0.1:1/sub"}
+	if _, err := Subscription(context.Background(), src, Validators{}); err == nil {
+		t.Fatal("expected direct fetch to fail")
+	}
+}
+
+func TestSubscriptionTunnelNotRunning(t *testing.T) {
+	setUpstreamAddr(t, "")
+	src := config.SubscriptionSource{Name: "tunnel", URL: "http://127.0.0.1:1/sub", FetchMode: config.FetchModeTunnel}
+	_, err := Subscription(context.Background(), src, Validators{})
+	if err == nil || !strings.Contains(err.Error(), "naive is not running") {
+		t.Fatalf("error = %v, want naive is not running", err)
+	}
+}
+
+// setUpstreamAddr 设置 naive 上游地址，测试结束时恢复
+func setUpstreamAddr(t *testing.T, addr string) {
+	prev := common.UpstreamAddr()
+	common.SetUpstreamAddr(addr)
+	t.Cleanup(func() { common.SetUpstreamAddr(prev) })
+}
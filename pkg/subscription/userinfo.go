@@ -0,0 +1,65 @@
+package subscription
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UserInfo 订阅的流量和到期信息，来自 Subscription-Userinfo 响应头
+type UserInfo struct {
+	Upload   int64 `json:"upload"`
+	Download int64 `json:"download"`
+	Total    int64 `json:"total"`
+	Expire   int64 `json:"expire"` // Unix 时间戳，0 表示不过期
+
+	UsedPercent     float64 `json:"used_percent"`                // 已用流量百分比，Total 为 0 时为 0
+	DaysUntilExpiry float64 `json:"days_until_expiry,omitempty"` // 距到期天数，Expire 为 0 时不返回
+}
+
+// ParseUserInfo 解析形如 "upload=1; download=2; total=3; expire=4" 的响应头
+func ParseUserInfo(header string) (*UserInfo, bool) {
+	if strings.TrimSpace(header) == "" {
+		return nil, false
+	}
+	info := new(UserInfo)
+	var found bool
+	for _, field := range strings.Split(header, ";") {
+		key, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "upload":
+			info.Upload = int64(n)
+		case "download":
+			info.Download = int64(n)
+		case "total":
+			info.Total = int64(n)
+		case "expire":
+			info.Expire = int64(n)
+		default:
+			continue
+		}
+		found = true
+	}
+	if !found {
+		return nil, false
+	}
+	return info, true
+}
+
+// withUsage 返回填充了已用百分比和剩余天数的副本
+func (u UserInfo) withUsage(now time.Time) UserInfo {
+	if u.Total > 0 {
+		u.UsedPercent = float64(u.Upload+u.Download) * 100 / float64(u.Total)
+	}
+	if u.Expire > 0 {
+		u.DaysUntilExpiry = time.Unix(u.Expire, 0).Sub(now).Hours() / 24
+	}
+	return u
+}
@@ -0,0 +1,22 @@
+package subscription
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseUserInfo(t *testing.T) {
+	info, ok := ParseUserInfo("upload=100; download=800; total=1000; expire=0")
+	if !ok {
+		t.Fatal("expected userinfo")
+	}
+	if info.Upload != 100 || info.Download != 800 || info.Total != 1000 || info.Expire != 0 {
+		t.Fatalf("unexpected userinfo: %+v", info)
+	}
+	if used := info.withUsage(time.Now()).UsedPercent; used != 90 {
+		t.Fatalf("used=%v expected=90", used)
+	}
+	if _, ok := ParseUserInfo(""); ok {
+		t.Fatal("expected no userinfo for empty header")
+	}
+}
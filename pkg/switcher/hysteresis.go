@@ -0,0 +1,82 @@
+package switcher
+
+import (
+	"encoding/json"
+	"time"
+
+	"naiveswitcher/internal/config"
+	"naiveswitcher/internal/types"
+	"naiveswitcher/pkg/log"
+	"naiveswitcher/pkg/subscription"
+)
+
+// decide 判断是否从当前节点切换到候选节点
+// avoid 为 true 时当前节点已被判定故障，不受迟滞限制
+// 当前节点正常时需满足最短使用时长，且评分的降低达到任一已配置的阈值
+// round_robin、weighted_random 本身就是轮换，只受最短使用时长限制
+func decide(h config.Hysteresis, selector string, probes []subscription.ProbeResult, current, candidate string,
+	switchedAt, now time.Time, avoid bool) types.SwitchDecision {
+	d := types.SwitchDecision{
+		Time:      now.Unix(),
+		Selector:  selector,
+		Current:   current,
+		Candidate: candidate,
+	}
+	if !switchedAt.IsZero() {
+		d.DwellSeconds = int64(now.Sub(switchedAt).Seconds())
+	}
+
+	var currentProbe *subscription.ProbeResult
+	for i := range probes {
+		switch probes[i].URL {
+		case current:
+			currentProbe = &probes[i]
+		case candidate:
+			d.CandidateScore = probes[i].Score
+		}
+	}
+	if currentProbe != nil {
+		d.CurrentScore = currentProbe.Score
+		d.ImprovementMs = d.CurrentScore - d.CandidateScore
+		if d.CurrentScore > 0 {
+			d.ImprovementPct = d.ImprovementMs / d.CurrentScore * 100
+		}
+	}
+
+	switch {
+	case candidate == current:
+		d.Reason = "already on the selected server"
+	case avoid:
+		d.Switch, d.Reason = true, "current server reported dead"
+	case current == "" || currentProbe == nil || !currentProbe.OK:
+		d.Switch, d.Reason = true, "current server unavailable"
+	case h.MinDwell > 0 && !switchedAt.IsZero() && now.Sub(switchedAt) < time.Duration(h.MinDwell)*time.Minute:
+		d.Reason = "min dwell time not reached"
+	case selector == config.SelectorRoundRobin || selector == config.SelectorWeightedRandom:
+		d.Switch, d.Reason = true, "rotation strategy"
+	case !improved(h, d):
+		d.Reason = "improvement below min_improvement_ms and min_improvement_percent"
+	default:
+		d.Switch, d.Reason = true, "improvement above threshold"
+	}
+	return d
+}
+
+// improved 判断评分的降低是否达到任一已配置的阈值，都未配置时不限制
+func improved(h config.Hysteresis, d types.SwitchDecision) bool {
+	if h.MinImprovementMs <= 0 && h.MinImprovementPercent <= 0 {
+		return true
+	}
+	return (h.MinImprovementMs > 0 && d.ImprovementMs >= h.MinImprovementMs) ||
+		(h.MinImprovementPercent > 0 && d.ImprovementPct >= h.MinImprovementPercent)
+}
+
+// recordDecision 以 JSON 记录切换决策，并保存为最近一次决策
+func recordDecision(state *types.GlobalState, d types.SwitchDecision) {
+	if data, err := json.Marshal(d); err == nil {
+		log.DebugF("Switch decision: %s\n", data)
+	}
+	state.LastProbeMutex.Lock()
+	state.LastDecision = &d
+	state.LastProbeMutex.Unlock()
+}
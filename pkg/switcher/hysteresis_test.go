@@ -0,0 +1,50 @@
+package switcher
+
+import (
+	"testing"
+	"time"
+
+	"naiveswitcher/internal/config"
+	"naiveswitcher/pkg/subscription"
+)
+
+func TestDecideHysteresis(t *testing.T) {
+	h := config.Hysteresis{MinImprovementPercent: 10, MinImprovementMs: 50, MinDwell: 10}
+	now := time.Now()
+	longAgo := now.Add(-time.Hour)
+	probes := func(current, candidate float64, currentOK bool) []subscription.ProbeResult {
+		return []subscription.ProbeResult{
+			{URL: "cur", OK: currentOK, Score: current},
+			{URL: "new", OK: true, Score: candidate},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		selector   string
+		probes     []subscription.ProbeResult
+		switchedAt time.Time
+		avoid      bool
+		want       bool
+	}{
+		{"big improvement", config.SelectorScore, probes(1000, 500, true), longAgo, false, true},
+		{"percent threshold only", config.SelectorScore, probes(300, 260, true), longAgo, false, true},
+		{"ms threshold only", config.SelectorScore, probes(1000, 920, true), longAgo, false, true},
+		{"below both thresholds", config.SelectorScore, probes(1000, 980, true), longAgo, false, false},
+		{"no improvement", config.SelectorScore, probes(300, 320, true), longAgo, false, false},
+		{"dwell not reached", config.SelectorScore, probes(1000, 100, true), now.Add(-time.Minute), false, false},
+		{"current failed", config.SelectorScore, probes(0, 500, false), now, false, true},
+		{"avoid dead server", config.SelectorScore, probes(1000, 990, true), now, true, true},
+		{"rotation ignores improvement", config.SelectorRoundRobin, probes(500, 900, true), longAgo, false, true},
+	}
+	for _, tt := range tests {
+		d := decide(h, tt.selector, tt.probes, "cur", "new", tt.switchedAt, now, tt.avoid)
+		if d.Switch != tt.want {
+			t.Fatalf("%s: expected switch=%v, got %+v", tt.name, tt.want, d)
+		}
+	}
+
+	if d := decide(h, config.SelectorScore, probes(1000, 500, true), "cur", "cur", longAgo, now, false); d.Switch {
+		t.Fatalf("same server must not switch: %+v", d)
+	}
+}
@@ -0,0 +1,31 @@
+package switcher
+
+import (
+	"naiveswitcher/internal/config"
+	"naiveswitcher/internal/types"
+	"naiveswitcher/pkg/log"
+	"naiveswitcher/pkg/subscription"
+)
+
+// RefreshNodes 刷新订阅并生成候选节点
+// 抓取失败的订阅源使用上一次成功（含磁盘缓存）的结果
+func RefreshNodes(state *types.GlobalState, cfg *config.Config, force bool) []subscription.Node {
+	opts := cfg.Current()
+	nodes, err := state.Subscriptions.Refresh(state.AppContext, opts.Sources(), force)
+	if err != nil {
+		log.DebugF("Error updating subscription: %v\n", err)
+	}
+	state.Subscriptions.CheckWarnings(opts)
+	return candidates(state, opts, nodes)
+}
+
+// CandidateNodes 使用现有的订阅结果生成候选节点，不发起网络请求
+func CandidateNodes(state *types.GlobalState, cfg *config.Config) []subscription.Node {
+	return candidates(state, cfg.Current(), state.Subscriptions.Nodes())
+}
+
+// candidates 订阅节点经过滤后，与静态节点合并
+func candidates(state *types.GlobalState, opts config.Options, nodes []subscription.Node) []subscription.Node {
+	nodes = subscription.Filter(nodes, opts.Filters)
+	return subscription.MergeStatic(nodes, state.StaticNodes.List(opts.Nodes))
+}
@@ -0,0 +1,85 @@
+package switcher
+
+import (
+	"net/url"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"naiveswitcher/internal/config"
+	"naiveswitcher/internal/types"
+	"naiveswitcher/pkg/log"
+	"naiveswitcher/pkg/naive"
+)
+
+// outputEventInterval 同一进程同类错误计入健康记录的最短间隔，避免每个失败的连接都计一次
+const outputEventInterval = time.Minute
+
+// outputHandler 将 naive 的输出写入日志，并把可识别的错误计入服务器的健康记录
+type outputHandler struct {
+	state   *types.GlobalState
+	cfg     *config.Config
+	cmd     *exec.Cmd
+	host    string
+	secrets []string // 代理 URL 中的凭据（编码和解码后的形式），输出到日志前隐藏
+
+	mu       sync.Mutex
+	reported map[string]time.Time
+}
+
+func newOutputHandler(state *types.GlobalState, cfg *config.Config, cmd *exec.Cmd, server string) *outputHandler {
+	h := &outputHandler{state: state, cfg: cfg, cmd: cmd, host: server, reported: make(map[string]time.Time)}
+	if u, err := url.Parse(server); err == nil {
+		h.host = u.Hostname()
+		if u.User != nil {
+			username := u.User.Username()
+			password, _ := u.User.Password()
+			h.secrets = []string{u.User.String(), username + ":" + password, password, username}
+			h.secrets = slices.DeleteFunc(h.secrets, func(s string) bool { return s == "" || s == ":" })
+			// 先替换较长的，避免用户名替换后剩下的部分无法匹配
+			slices.SortFunc(h.secrets, func(a, b string) int { return len(b) - len(a) })
+			h.secrets = slices.Compact(h.secrets)
+		}
+	}
+	return h
+}
+
+// mask 隐藏输出行中的凭据
+func (h *outputHandler) mask(line string) string {
+	for _, s := range h.secrets {
+		line = strings.ReplaceAll(line, s, "***")
+	}
+	return line
+}
+
+// handle 返回某个输出流的按行回调
+func (h *outputHandler) handle(stream string) func(string) {
+	return func(line string) {
+		line = h.mask(line)
+		// 输出只会在 Start 之后产生，此时 Process 已经设置
+		pid := h.cmd.Process.Pid
+		log.DebugF("[naive %d %s %s] %s\n", pid, h.host, stream, line)
+
+		kind := naive.Classify(line)
+		if kind == "" || !h.shouldReport(kind) {
+			return
+		}
+		log.Warn("naive_"+kind, "naive (PID %d) reported %s error for %s: %s", pid, kind, h.host, line)
+		if err := h.state.Health.RecordEvent(h.host, kind, h.cfg.Current().HealthDecay()); err != nil {
+			log.DebugF("Save health records error: %v\n", err)
+		}
+	}
+}
+
+func (h *outputHandler) shouldReport(kind string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	if last, ok := h.reported[kind]; ok && now.Sub(last) < outputEventInterval {
+		return false
+	}
+	h.reported[kind] = now
+	return true
+}
@@ -0,0 +1,133 @@
+package switcher
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"naiveswitcher/internal/config"
+	"naiveswitcher/internal/types"
+	"naiveswitcher/pkg/log"
+)
+
+// ActiveProbe 定期经由当前 naive 进程请求 active_probe.url，没有用户流量时也能发现故障
+// 连续失败达到 failure_threshold 次后请求 avoid_auto 切换
+func ActiveProbe(state *types.GlobalState, cfg *config.Config, doSwitch chan<- types.SwitchRequest) {
+	for {
+		opts := cfg.Current().ActiveProbe
+		interval := time.Duration(opts.Interval) * time.Second
+		if interval <= 0 {
+			interval = time.Minute // 未启用时仍定期检查配置是否变更
+		}
+		select {
+		case <-state.AppContext.Done():
+			return
+		case <-time.After(interval):
+		}
+
+		opts = cfg.Current().ActiveProbe
+		p := state.Naive.Load()
+		if opts.Interval <= 0 || p == nil {
+			continue
+		}
+
+		latency, err := probeUpstream(state.AppContext, p.Addr, opts)
+		if state.AppContext.Err() != nil {
+			return
+		}
+		// 探测期间已切换，结果不再代表当前服务器
+		if state.Naive.Load() != p {
+			continue
+		}
+		failures := recordActiveProbe(state, p.Server, latency, err)
+		if err == nil {
+			continue
+		}
+		log.DebugF("Active probe via %s failed (%d/%d): %v\n", p.Server, failures, opts.FailureThreshold, err)
+		if failures < opts.FailureThreshold {
+			continue
+		}
+		if autoSwitchPaused(state) {
+			log.DebugF("Auto switch paused, not switching after active probe failures\n")
+			continue
+		}
+		log.Warn("active_probe", "active probe failed %d times in a row via %s, switching server", failures, p.Server)
+		resetActiveProbe(state)
+		select {
+		case doSwitch <- types.SwitchRequest{Type: "avoid_auto", AvoidServer: p.Server}:
+		case <-state.AppContext.Done():
+			return
+		}
+	}
+}
+
+// probeUpstream 经由 naive 的 SOCKS 端口请求探测地址，返回响应头到达的耗时
+func probeUpstream(ctx context.Context, addr string, opts config.ActiveProbe) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(opts.Timeout)*time.Second)
+	defer cancel()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy:             http.ProxyURL(&url.URL{Scheme: "socks5", Host: addr}),
+			DisableKeepAlives: true,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, opts.URL, nil)
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	latency := time.Since(start)
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+	if resp.StatusCode != opts.ExpectStatus {
+		return latency, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return latency, nil
+}
+
+// recordActiveProbe 记录一次探测结果，服务器变化时重新计数，返回连续失败次数
+func recordActiveProbe(state *types.GlobalState, server string, latency time.Duration, err error) int {
+	state.ActiveProbeMutex.Lock()
+	defer state.ActiveProbeMutex.Unlock()
+	st := &state.ActiveProbe
+	if st.Server != server {
+		*st = types.ActiveProbeStatus{Server: server}
+	}
+	st.Time = time.Now().Unix()
+	st.OK = err == nil
+	st.LatencyMs = latency.Milliseconds()
+	st.Error = ""
+	if err != nil {
+		st.Error = err.Error()
+		st.Failures++
+		st.ConsecutiveFailures++
+	} else {
+		st.Successes++
+		st.ConsecutiveFailures = 0
+	}
+	return st.ConsecutiveFailures
+}
+
+func resetActiveProbe(state *types.GlobalState) {
+	state.ActiveProbeMutex.Lock()
+	state.ActiveProbe.ConsecutiveFailures = 0
+	state.ActiveProbeMutex.Unlock()
+}
+
+// ActiveProbeStatus 返回主动探测的统计
+func ActiveProbeStatus(state *types.GlobalState) types.ActiveProbeStatus {
+	state.ActiveProbeMutex.RLock()
+	defer state.ActiveProbeMutex.RUnlock()
+	return state.ActiveProbe
+}
@@ -0,0 +1,50 @@
+package switcher
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"naiveswitcher/internal/config"
+	"naiveswitcher/internal/sockstest"
+	"naiveswitcher/internal/types"
+)
+
+func TestProbeUpstream(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusNoContent)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer srv.Close()
+	addr := sockstest.Serve(t, sockstest.Relay)
+	opts := config.ActiveProbe{URL: srv.URL + "/generate_204", ExpectStatus: http.StatusNoContent, Timeout: 2}
+
+	if _, err := probeUpstream(context.Background(), addr, opts); err != nil {
+		t.Fatalf("expected probe to succeed, got %v", err)
+	}
+	status.Store(http.StatusOK)
+	if _, err := probeUpstream(context.Background(), addr, opts); err == nil {
+		t.Fatal("expected error for unexpected status")
+	}
+}
+
+func TestRecordActiveProbe(t *testing.T) {
+	state := &types.GlobalState{}
+	recordActiveProbe(state, "a", 0, io.EOF)
+	if n := recordActiveProbe(state, "a", 0, io.EOF); n != 2 {
+		t.Fatalf("consecutive failures = %d, want 2", n)
+	}
+	if n := recordActiveProbe(state, "b", 0, io.EOF); n != 1 {
+		t.Fatalf("consecutive failures after server change = %d, want 1", n)
+	}
+	if n := recordActiveProbe(state, "b", 0, nil); n != 0 {
+		t.Fatalf("consecutive failures after success = %d, want 0", n)
+	}
+	if st := ActiveProbeStatus(state); st.Successes != 1 || st.Failures != 1 || !st.OK {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+}
@@ -1,114 +1,373 @@
 package switcher
 
 import (
+	"context"
 	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"time"
 
+	"naiveswitcher/internal/config"
 	"naiveswitcher/internal/types"
 	"naiveswitcher/pkg/common"
 	"naiveswitcher/pkg/log"
 	"naiveswitcher/pkg/naive"
+	"naiveswitcher/pkg/subscription"
 )
 
-// StopNaive 安全地停止 naive 进程（需要外部已获取锁）
-func stopNaiveUnsafe(state *types.GlobalState) {
-	if state.NaiveCmd == nil {
-		return
-	}
+// maxLaunchAttempts 新进程未能监听端口时的最多启动次数
+const maxLaunchAttempts = 3
+
+// errReplaced 重启期间当前进程已被其他切换替换
+var errReplaced = errors.New("naive process replaced during restart")
+
+// stopProcess 停止 naive 进程，退出不视为崩溃
+func stopProcess(p *types.NaiveProcess) {
+	p.Stopping.Store(true)
 
 	// 1. 先取消 context，触发进程优雅退出
-	if state.NaiveCmdCancel != nil {
-		state.NaiveCmdCancel()
-		state.NaiveCmdCancel = nil
+	if p.Cancel != nil {
+		p.Cancel()
 	}
 
 	// 2. 如果进程还在运行，尝试终止
-	if state.NaiveCmd.Process != nil {
-		pid := state.NaiveCmd.Process.Pid
-		naive.KillProcessGroup(state, pid)
+	if p.Cmd != nil && p.Cmd.Process != nil {
+		naive.KillProcessGroup(p.Cmd, p.Done)
+	}
+}
+
+// startProcess 使用 binary 在新的本地端口上启动 naive 进程
+func startProcess(state *types.GlobalState, cfg *config.Config, targetServer string, binary string) (*types.NaiveProcess, error) {
+	host, lo, hi, err := cfg.Current().UpstreamPorts()
+	if err != nil {
+		return nil, err
+	}
+	addr, err := naive.FreeAddr(host, lo, hi)
+	if err != nil {
+		return nil, err
+	}
+
+	// 节点名称用于匹配按节点配置的 naive 选项
+	node, _ := subscription.FindNode(state.NodeList(), targetServer)
+	naiveConfig := naive.RenderConfig(targetServer, addr, cfg.Current().Naive.For(node.Name, targetServer))
+	configPath := naive.ConfigPath(addr)
+	cmd, cancel, err := naive.NaiveCmd(state, binary, naiveConfig, configPath)
+	if err != nil {
+		log.DebugF("Error creating naive command: %v\n", err)
+		return nil, err
+	}
+	out := newOutputHandler(state, cfg, cmd, targetServer)
+	cmd.Stdout = naive.NewLineWriter(out.handle("stdout"))
+	cmd.Stderr = naive.NewLineWriter(out.handle("stderr"))
+	cmd.WaitDelay = time.Second // 子进程继承了输出管道时不会一直阻塞 Wait
+	if err := cmd.Start(); err != nil {
+		log.DebugF("Error starting naive: %v\n", err)
+		// 如果启动失败，取消 context 释放资源
+		cancel()
+		removeConfig(configPath)
+		return nil, err
+	}
+	p := newProcess(cmd, cancel, targetServer, addr)
+	p.Binary = binary
+	p.Config = naiveConfig
+	return p, nil
+}
+
+// newProcess 包装已启动的命令，每个进程只在这里 Wait 一次，其他地方通过 Done 等待退出
+func newProcess(cmd *exec.Cmd, cancel context.CancelFunc, server string, addr string) *types.NaiveProcess {
+	p := &types.NaiveProcess{
+		Cmd:       cmd,
+		Cancel:    cancel,
+		Server:    server,
+		Addr:      addr,
+		StartedAt: time.Now(),
+		Done:      make(chan struct{}),
+	}
+	go func() {
+		p.ExitErr = cmd.Wait()
+		for _, w := range []io.Writer{cmd.Stdout, cmd.Stderr} {
+			if lw, ok := w.(*naive.LineWriter); ok {
+				lw.Flush()
+			}
+		}
+		close(p.Done)
+	}()
+	return p
+}
+
+// removeConfig 删除 naive 启动后不再需要的配置文件，其中包含凭据
+func removeConfig(path string) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.DebugF("Remove naive config error: %v\n", err)
 	}
+}
 
-	state.NaiveCmd = nil
+// RestartNaive 切换 naive 进程到指定服务器
+// 新进程在新端口启动，完成 SOCKS 握手和测试 CONNECT 后才接收新连接，旧进程的连接在 drain_timeout 内继续使用旧进程
+// 新进程启动失败或在 ready_timeout 内未就绪时停止新进程，继续使用旧进程和原服务器
+func RestartNaive(state *types.GlobalState, cfg *config.Config, targetServer string) error {
+	return restartNaive(state, cfg, targetServer, "", nil)
 }
 
-// StartNaive 安全地启动 naive 进程（需要外部已获取锁）
-func startNaiveUnsafe(state *types.GlobalState, targetServer string) error {
+// UpgradeNaive 使用新的 naive 可执行文件重启当前节点，没有运行中的进程时只替换可执行文件
+// 旧的可执行文件在最后一个使用它的进程停止后删除
+func UpgradeNaive(state *types.GlobalState, cfg *config.Config, binary string) error {
+	for {
+		p := state.Naive.Load()
+		if p == nil {
+			state.NaiveCmdLock.Lock()
+			setBinaryUnsafe(state, binary)
+			removeRetiredUnsafe(state)
+			state.NaiveCmdLock.Unlock()
+			return nil
+		}
+		// 当前进程被其他切换替换时在新的节点上重试
+		if err := restartNaive(state, cfg, p.Server, binary, p); !errors.Is(err, errReplaced) {
+			return err
+		}
+	}
+}
+
+// NaiveBinary 返回当前使用的 naive 可执行文件
+func NaiveBinary(state *types.GlobalState) string {
+	state.NaiveCmdLock.Lock()
+	defer state.NaiveCmdLock.Unlock()
+	return common.Naive
+}
+
+// launchProcess 启动 naive 进程并等待就绪
+// FreeAddr 释放端口后到 naive 开始监听之前，端口可能被其他程序占用，naive 无法监听而退出，
+// 此时换一个端口重试，最多 maxLaunchAttempts 次
+func launchProcess(state *types.GlobalState, cfg *config.Config, targetServer string, binary string) (*types.NaiveProcess, error) {
+	opts := cfg.Current()
+	for attempt := 1; ; attempt++ {
+		p, err := startProcess(state, cfg, targetServer, binary)
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(state.AppContext, opts.ReadyWait())
+		go func() {
+			// 进程退出后不必等到超时
+			select {
+			case <-p.Done:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		err = naive.WaitReady(ctx, p.Addr, opts.ReadyTarget)
+		cancel()
+		// 就绪后 naive 已读取配置，不在磁盘上保留凭据
+		removeConfig(naive.ConfigPath(p.Addr))
+		if err == nil {
+			log.DebugF("Naive process (PID: %d) ready after %s\n", p.Cmd.Process.Pid, time.Since(p.StartedAt).Round(time.Millisecond))
+			return p, nil
+		}
+		exited := p.Exited()
+		stopProcess(p)
+		if exited && attempt < maxLaunchAttempts && state.AppContext.Err() == nil {
+			log.DebugF("Naive process (PID: %d) exited before listening on %s, retrying on another port\n", p.Cmd.Process.Pid, p.Addr)
+			continue
+		}
+		log.DebugF("Naive process (PID: %d) for %s not ready, keeping previous server: %v\n", p.Cmd.Process.Pid, targetServer, err)
+		return nil, err
+	}
+}
+
+// restartNaive 使用 binary 启动新进程并替换当前进程，binary 为空时使用当前的可执行文件
+// expected 非空时，当前进程已不是 expected 则放弃并返回 errReplaced
+// 启动和等待就绪不持有 NaiveCmdLock，只在替换和排空时持有
+func restartNaive(state *types.GlobalState, cfg *config.Config, targetServer string, binary string, expected *types.NaiveProcess) error {
 	// 检查应用程序上下文是否已经取消
-	select {
-	case <-state.AppContext.Done():
+	if state.AppContext.Err() != nil {
 		log.DebugF("Application is shutting down, not starting naive process\n")
 		return nil // 不启动新进程，但不返回错误
-	default:
-		// 继续启动进程
+	}
+	if expected != nil && state.Naive.Load() != expected {
+		return errReplaced
+	}
+	if binary == "" {
+		binary = NaiveBinary(state)
 	}
 
-	var err error
-	state.NaiveCmd, state.NaiveCmdCancel, err = naive.NaiveCmd(state, targetServer)
+	opts := cfg.Current()
+	p, err := launchProcess(state, cfg, targetServer, binary)
 	if err != nil {
-		log.DebugF("Error creating naive command: %v\n", err)
 		return err
 	}
-	if err := state.NaiveCmd.Start(); err != nil {
-		log.DebugF("Error starting naive: %v\n", err)
-		// 如果启动失败，取消 context 释放资源
-		if state.NaiveCmdCancel != nil {
-			state.NaiveCmdCancel()
-			state.NaiveCmdCancel = nil
+
+	state.NaiveCmdLock.Lock()
+	defer state.NaiveCmdLock.Unlock()
+	// 等待期间应用退出或当前进程被替换时放弃新进程
+	if state.AppContext.Err() != nil {
+		stopProcess(p)
+		return nil
+	}
+	if expected != nil && state.Naive.Load() != expected {
+		stopProcess(p)
+		return errReplaced
+	}
+
+	old := state.Naive.Swap(p)
+	common.SetUpstreamAddr(p.Addr)
+	state.SwitchedAt = p.StartedAt
+	setBinaryUnsafe(state, binary)
+	log.DebugF("Successfully started naive process (PID: %d) on %s for server: %s\n", p.Cmd.Process.Pid, p.Addr, targetServer)
+
+	if old != nil {
+		if old.Exited() {
+			stopProcess(old) // 已崩溃的进程无需排空，只需释放资源
+		} else {
+			drainUnsafe(state, old, opts.DrainGrace())
 		}
-		state.NaiveCmd = nil
-		return err
 	}
-	log.DebugF("Successfully started naive process (PID: %d) for server: %s\n", state.NaiveCmd.Process.Pid, targetServer)
+	removeRetiredUnsafe(state)
 	return nil
 }
 
-// RestartNaive 重启 naive 进程到指定服务器
-func RestartNaive(state *types.GlobalState, targetServer string) error {
+// setBinaryUnsafe 替换当前的 naive 可执行文件，旧文件留待删除（需要外部已获取锁）
+func setBinaryUnsafe(state *types.GlobalState, binary string) {
+	if binary == common.Naive {
+		return
+	}
+	state.Retired = slices.DeleteFunc(state.Retired, func(b string) bool { return b == binary })
+	if common.Naive != "" {
+		state.Retired = append(state.Retired, common.Naive)
+	}
+	common.Naive = binary
+}
+
+// removeRetiredUnsafe 删除不再被任何进程使用的旧 naive 可执行文件（需要外部已获取锁）
+func removeRetiredUnsafe(state *types.GlobalState) {
+	inUse := make(map[string]bool)
+	if p := state.Naive.Load(); p != nil {
+		inUse[p.Binary] = true
+	}
+	for _, p := range state.Draining {
+		inUse[p.Binary] = true
+	}
+	retired := state.Retired[:0]
+	for _, binary := range state.Retired {
+		if inUse[binary] {
+			retired = append(retired, binary)
+			continue
+		}
+		// Windows 上进程刚被终止时可能删除失败，不再重试
+		if err := os.Remove(common.BasePath + "/" + binary); err != nil {
+			log.DebugF("Error removing old naive %s: %v\n", binary, err)
+		} else {
+			log.DebugF("Removed old naive %s\n", binary)
+		}
+	}
+	state.Retired = retired
+}
+
+// drainUnsafe 旧进程不再接收新连接，连接全部结束或超时后停止（需要外部已获取锁）
+func drainUnsafe(state *types.GlobalState, p *types.NaiveProcess, grace time.Duration) {
+	if grace <= 0 || p.Conns.Load() == 0 {
+		stopProcess(p)
+		return
+	}
+	p.DrainAt = time.Now()
+	state.Draining = append(state.Draining, p)
+	log.DebugF("Draining naive process (PID: %d) with %d connections\n", p.Cmd.Process.Pid, p.Conns.Load())
+
+	go func() {
+		timeout := time.NewTimer(grace)
+		defer timeout.Stop()
+		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+	WAIT:
+		for {
+			select {
+			case <-state.AppContext.Done():
+				return // 由 StopAll 负责停止
+			case <-timeout.C:
+				log.DebugF("Drain timeout, stopping naive process (PID: %d) with %d connections\n", p.Cmd.Process.Pid, p.Conns.Load())
+				break WAIT
+			case <-ticker.C:
+				if p.Conns.Load() == 0 {
+					break WAIT
+				}
+			}
+		}
+
+		state.NaiveCmdLock.Lock()
+		defer state.NaiveCmdLock.Unlock()
+		for i, d := range state.Draining {
+			if d == p {
+				state.Draining = append(state.Draining[:i], state.Draining[i+1:]...)
+				stopProcess(p)
+				removeRetiredUnsafe(state)
+				return
+			}
+		}
+	}()
+}
+
+// StopAll 停止所有 naive 进程，用于退出
+func StopAll(state *types.GlobalState) {
 	state.NaiveCmdLock.Lock()
 	defer state.NaiveCmdLock.Unlock()
 
-	// 停止当前进程
-	stopNaiveUnsafe(state)
+	if p := state.Naive.Swap(nil); p != nil {
+		println("Terminating naive process (PID:", p.Cmd.Process.Pid, ")")
+		stopProcess(p)
+	}
+	for _, p := range state.Draining {
+		println("Terminating draining naive process (PID:", p.Cmd.Process.Pid, ")")
+		stopProcess(p)
+	}
+	state.Draining = nil
+}
 
-	// 启动新进程
-	return startNaiveUnsafe(state, targetServer)
+// NaiveStatus 返回当前和排空中的 naive 进程状态
+func NaiveStatus(state *types.GlobalState, cfg *config.Config) (active *types.NaiveStatus, draining []types.NaiveStatus) {
+	grace := cfg.Current().DrainGrace()
+	if p := state.Naive.Load(); p != nil {
+		st := p.Status(grace)
+		active = &st
+	}
+	state.NaiveCmdLock.Lock()
+	defer state.NaiveCmdLock.Unlock()
+	draining = make([]types.NaiveStatus, 0, len(state.Draining))
+	for _, p := range state.Draining {
+		draining = append(draining, p.Status(grace))
+	}
+	return active, draining
 }
 
 // ProcessSelectRequest 处理直接选择服务器的请求
-func ProcessSelectRequest(state *types.GlobalState, req types.SwitchRequest) error {
+func ProcessSelectRequest(state *types.GlobalState, cfg *config.Config, req types.SwitchRequest) error {
 	if req.TargetServer == "" {
 		return errors.New("target server cannot be empty")
 	}
 
-	// 验证目标服务器是否在可用列表中
-	var found bool
-	for _, server := range state.HostUrls {
-		if server == req.TargetServer {
-			found = true
-			break
-		}
-	}
-
+	// 验证目标服务器是否在可用列表中，支持按 URL 或节点名称选择
+	node, found := subscription.FindNode(state.NodeList(), req.TargetServer)
 	if !found {
 		return errors.New("target server not found in available servers")
 	}
 
-	if state.FastestUrl == req.TargetServer {
+	if state.FastestUrl == node.URL {
 		return errors.New("already connected to target server")
 	}
 
-	log.DebugF("Switching to selected server: %s\n", req.TargetServer)
+	log.DebugF("Switching to selected server: %s (%s)\n", node.Name, node.URL)
 
-	if err := RestartNaive(state, req.TargetServer); err != nil {
+	if err := RestartNaive(state, cfg, node.URL); err != nil {
 		return err
 	}
 
-	state.FastestUrl = req.TargetServer
+	state.FastestUrl = node.URL
 
 	state.AutoSwitchMutex.Lock()
 	state.LockedServer = state.FastestUrl
 	ps := types.PersistedState{
 		AutoSwitchPaused: state.AutoSwitchPaused,
 		LockedServer:     state.LockedServer,
+		Selector:         state.Selector,
 	}
 	state.AutoSwitchMutex.Unlock()
 	if err := types.SavePersistedState(common.BasePath, ps); err != nil {
@@ -0,0 +1,155 @@
+//go:build unix
+
+package switcher
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"naiveswitcher/internal/config"
+	"naiveswitcher/internal/types"
+	"naiveswitcher/pkg/common"
+)
+
+func sleepProcess(t *testing.T) *types.NaiveProcess {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := exec.CommandContext(ctx, "sleep", "30")
+	if err := cmd.Start(); err != nil {
+		cancel()
+		t.Skipf("start sleep: %v", err)
+	}
+	return newProcess(cmd, cancel, "https://u:p@example.com:443", "127.0.0.1:0")
+}
+
+func TestDrainStopsAfterConnectionsClose(t *testing.T) {
+	state := &types.GlobalState{AppContext: context.Background()}
+	p := sleepProcess(t)
+	p.Conns.Add(1)
+
+	state.NaiveCmdLock.Lock()
+	drainUnsafe(state, p, time.Minute)
+	state.NaiveCmdLock.Unlock()
+
+	state.NaiveCmdLock.Lock()
+	draining := len(state.Draining)
+	state.NaiveCmdLock.Unlock()
+	if draining != 1 {
+		t.Fatalf("expected process to be draining, got %d", draining)
+	}
+	if st := p.Status(time.Minute); st.Conns != 1 || st.Remaining <= 0 {
+		t.Fatalf("unexpected drain status: %+v", st)
+	}
+
+	p.Conns.Add(-1)
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		state.NaiveCmdLock.Lock()
+		draining = len(state.Draining)
+		state.NaiveCmdLock.Unlock()
+		if draining == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("draining process was not stopped after its connections closed")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if !p.Exited() || !p.Stopping.Load() {
+		t.Fatal("expected process to have been stopped")
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	state := &types.GlobalState{AppContext: context.Background()}
+	p := sleepProcess(t)
+	p.Conns.Add(1)
+
+	state.NaiveCmdLock.Lock()
+	drainUnsafe(state, p, 200*time.Millisecond)
+	state.NaiveCmdLock.Unlock()
+
+	time.Sleep(1500 * time.Millisecond)
+	state.NaiveCmdLock.Lock()
+	defer state.NaiveCmdLock.Unlock()
+	if len(state.Draining) != 0 || !p.Exited() {
+		t.Fatal("expected draining process to be stopped after grace timeout")
+	}
+}
+
+func TestRetiredBinaryRemovedAfterDrain(t *testing.T) {
+	basePath, binary := common.BasePath, common.Naive
+	common.BasePath = t.TempDir()
+	t.Cleanup(func() { common.BasePath, common.Naive = basePath, binary })
+	for _, name := range []string{"naive-old", "naive-new"} {
+		if err := os.WriteFile(filepath.Join(common.BasePath, name), nil, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	state := &types.GlobalState{AppContext: context.Background()}
+	p := sleepProcess(t)
+	p.Binary = "naive-old"
+	p.Conns.Add(1)
+	state.NaiveCmdLock.Lock()
+	common.Naive = "naive-old"
+	drainUnsafe(state, p, time.Minute)
+	setBinaryUnsafe(state, "naive-new")
+	removeRetiredUnsafe(state)
+	state.NaiveCmdLock.Unlock()
+
+	if _, err := os.Stat(filepath.Join(common.BasePath, "naive-old")); err != nil {
+		t.Fatalf("old binary removed while still draining: %v", err)
+	}
+	p.Conns.Add(-1)
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		if _, err := os.Stat(filepath.Join(common.BasePath, "naive-old")); os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("old binary was not removed after its last process stopped")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if NaiveBinary(state) != "naive-new" {
+		t.Fatalf("binary = %s, want naive-new", NaiveBinary(state))
+	}
+	if _, err := os.Stat(filepath.Join(common.BasePath, "naive-new")); err != nil {
+		t.Fatalf("new binary removed: %v", err)
+	}
+}
+
+func TestRecordCrashWindow(t *testing.T) {
+	state := &types.GlobalState{AppContext: context.Background()}
+	opts := config.Supervisor{MaxCrashes: 3, Window: 10, Backoff: 1}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := exec.CommandContext(ctx, "sh", "-c", "exit 3")
+	if err := cmd.Start(); err != nil {
+		cancel()
+		t.Skipf("start sh: %v", err)
+	}
+	p := newProcess(cmd, cancel, "https://u:p@example.com:443", "127.0.0.1:0")
+	<-p.Done
+
+	// 窗口外的崩溃不计入
+	state.NaiveCrashes = []time.Time{time.Now().Add(-time.Hour), time.Now().Add(-time.Minute)}
+	if n := recordCrash(state, p, opts); n != 2 {
+		t.Fatalf("crashes in window = %d, want 2", n)
+	}
+	st := SupervisorStatus(state)
+	if st.LastExit == nil || st.LastExit.Code != 3 {
+		t.Fatalf("last exit = %+v, want code 3", st.LastExit)
+	}
+
+	for crashes, want := range map[int]time.Duration{1: time.Second, 3: 4 * time.Second, 10: maxRestartBackoff} {
+		if got := restartBackoff(opts, crashes); got != want {
+			t.Errorf("restartBackoff(%d) = %s, want %s", crashes, got, want)
+		}
+	}
+}
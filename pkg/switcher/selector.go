@@ -0,0 +1,63 @@
+package switcher
+
+import (
+	"fmt"
+	"slices"
+
+	"naiveswitcher/internal/config"
+	"naiveswitcher/internal/types"
+	"naiveswitcher/pkg/common"
+	"naiveswitcher/pkg/log"
+	"naiveswitcher/pkg/subscription"
+)
+
+// SelectorName 返回当前生效的选择策略名称，通过 API 设置的策略优先于配置
+func SelectorName(state *types.GlobalState, cfg *config.Config) string {
+	state.AutoSwitchMutex.RLock()
+	name := state.Selector
+	state.AutoSwitchMutex.RUnlock()
+	if name != "" {
+		return name
+	}
+	if name = cfg.Current().Selector; name != "" {
+		return name
+	}
+	return config.SelectorScore
+}
+
+// ActiveSelector 创建当前生效的选择策略，failover 列表中的节点名称解析为 URL
+func ActiveSelector(state *types.GlobalState, cfg *config.Config, nodes []subscription.Node) (subscription.Selector, error) {
+	var order []string
+	for _, want := range cfg.Current().Failover {
+		if node, ok := subscription.FindNode(nodes, want); ok {
+			want = node.URL
+		}
+		order = append(order, want)
+	}
+	return subscription.NewSelector(SelectorName(state, cfg), order)
+}
+
+// SetSelector 运行时切换选择策略并持久化，name 为空时恢复使用配置
+// 配置中没有 failover 列表时拒绝切换到 failover
+func SetSelector(state *types.GlobalState, cfg *config.Config, name string) error {
+	if name != "" && !slices.Contains(config.Selectors, name) {
+		return fmt.Errorf("unknown selector: %s", name)
+	}
+	if name == config.SelectorFailover && len(cfg.Current().Failover) == 0 {
+		return fmt.Errorf("selector %s requires a non-empty failover list in config", name)
+	}
+
+	state.AutoSwitchMutex.Lock()
+	state.Selector = name
+	ps := types.PersistedState{
+		AutoSwitchPaused: state.AutoSwitchPaused,
+		LockedServer:     state.LockedServer,
+		Selector:         state.Selector,
+	}
+	state.AutoSwitchMutex.Unlock()
+
+	if err := types.SavePersistedState(common.BasePath, ps); err != nil {
+		log.DebugF("Save persisted state error: %v\n", err)
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+package switcher
+
+import (
+	"testing"
+
+	"naiveswitcher/internal/config"
+	"naiveswitcher/internal/types"
+)
+
+func TestSetSelectorRejectsEmptyFailover(t *testing.T) {
+	state := &types.GlobalState{}
+	if err := SetSelector(state, config.NewConfig("test"), config.SelectorFailover); err == nil {
+		t.Fatal("expected error for failover without a failover list")
+	}
+	if state.Selector != "" {
+		t.Fatalf("selector = %q, want unchanged", state.Selector)
+	}
+}
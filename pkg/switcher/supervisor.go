@@ -0,0 +1,167 @@
+package switcher
+
+import (
+	"errors"
+	"os/exec"
+	"time"
+
+	"naiveswitcher/internal/config"
+	"naiveswitcher/internal/types"
+	"naiveswitcher/pkg/log"
+)
+
+// maxRestartBackoff 自动重启的最长等待时间
+const maxRestartBackoff = 30 * time.Second
+
+// Supervise 监视当前的 naive 进程，意外退出时在同一服务器上按指数退避重启
+// 统计窗口内崩溃次数达到 max_crashes 后改为请求 avoid_auto 切换到其他服务器
+func Supervise(state *types.GlobalState, cfg *config.Config, doSwitch chan<- types.SwitchRequest) {
+	var handled *types.NaiveProcess // 已处理过退出的进程，不再重复处理
+	for {
+		p := state.Naive.Load()
+		var done <-chan struct{}
+		if p != nil && p != handled {
+			done = p.Done
+		}
+		select {
+		case <-state.AppContext.Done():
+			return
+		case <-done:
+		case <-time.After(time.Second):
+			continue // 当前进程可能已被切换替换，重新读取
+		}
+		handled = p
+
+		// 主动停止或已被替换的进程不处理
+		if p.Stopping.Load() || state.Naive.Load() != p {
+			continue
+		}
+		if !restartCrashed(state, cfg, p, doSwitch) {
+			return
+		}
+	}
+}
+
+// restartCrashed 处理进程 p 的一次意外退出，应用退出时返回 false
+// 每次退出只记录一次崩溃，重启失败单独计数，两者之和达到 max_crashes 后请求切换
+func restartCrashed(state *types.GlobalState, cfg *config.Config, p *types.NaiveProcess, doSwitch chan<- types.SwitchRequest) bool {
+	opts := cfg.Current().Supervisor
+	crashes := recordCrash(state, p, opts)
+	for failures := crashes; ; failures++ {
+		if opts.MaxCrashes > 0 && failures >= opts.MaxCrashes && !autoSwitchPaused(state) {
+			log.Warn("naive_crash", "naive for %s failed %d times in %d minutes, switching server",
+				p.Server, failures, opts.Window)
+			resetCrashes(state)
+			select {
+			case doSwitch <- types.SwitchRequest{Type: "avoid_auto", AvoidServer: p.Server}:
+			case <-state.AppContext.Done():
+				return false
+			}
+			// 等待切换完成，避免重复处理同一次退出
+			waitReplaced(state, p)
+			return true
+		}
+
+		backoff := restartBackoff(opts, failures)
+		log.DebugF("Restarting naive for %s in %s\n", p.Server, backoff)
+		select {
+		case <-state.AppContext.Done():
+			return false
+		case <-time.After(backoff):
+		}
+		err := restartNaive(state, cfg, p.Server, "", p)
+		if errors.Is(err, errReplaced) {
+			return true // 等待期间已切换
+		}
+		state.SupervisorMutex.Lock()
+		if err == nil {
+			state.Supervisor.Restarts++
+		} else {
+			state.Supervisor.RestartFailures++
+		}
+		state.SupervisorMutex.Unlock()
+		if err == nil {
+			return true
+		}
+		log.DebugF("Restart naive error: %v\n", err)
+	}
+}
+
+// recordCrash 记录一次意外退出，返回统计窗口内的崩溃次数
+func recordCrash(state *types.GlobalState, p *types.NaiveProcess, opts config.Supervisor) int {
+	exit := &types.NaiveExit{
+		PID:    p.Cmd.Process.Pid,
+		Server: p.Server,
+		Code:   -1,
+		Time:   time.Now().Unix(),
+	}
+	var exitErr *exec.ExitError
+	if errors.As(p.ExitErr, &exitErr) {
+		exit.Code = exitErr.ExitCode()
+	} else if p.Cmd.ProcessState != nil {
+		exit.Code = p.Cmd.ProcessState.ExitCode()
+	}
+	if p.ExitErr != nil {
+		exit.Error = p.ExitErr.Error()
+	}
+	log.Warn("naive_exit", "naive (PID %d) for %s exited unexpectedly, code %d", exit.PID, exit.Server, exit.Code)
+
+	now := time.Now()
+	cutoff := now.Add(-time.Duration(opts.Window) * time.Minute)
+	state.SupervisorMutex.Lock()
+	defer state.SupervisorMutex.Unlock()
+	crashes := state.NaiveCrashes[:0]
+	for _, t := range state.NaiveCrashes {
+		if t.After(cutoff) {
+			crashes = append(crashes, t)
+		}
+	}
+	state.NaiveCrashes = append(crashes, now)
+	state.Supervisor.Crashes = len(state.NaiveCrashes)
+	state.Supervisor.LastExit = exit
+	return state.Supervisor.Crashes
+}
+
+func resetCrashes(state *types.GlobalState) {
+	state.SupervisorMutex.Lock()
+	state.NaiveCrashes = nil
+	state.Supervisor.Crashes = 0
+	state.SupervisorMutex.Unlock()
+}
+
+// restartBackoff 第 n 次崩溃后的等待时间：backoff * 2^(n-1)，不超过 maxRestartBackoff
+func restartBackoff(opts config.Supervisor, crashes int) time.Duration {
+	backoff := time.Duration(opts.Backoff) * time.Second
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+	for i := 1; i < crashes && backoff < maxRestartBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxRestartBackoff)
+}
+
+// waitReplaced 等待当前进程被替换，最长 1 分钟
+func waitReplaced(state *types.GlobalState, p *types.NaiveProcess) {
+	deadline := time.Now().Add(time.Minute)
+	for state.Naive.Load() == p && time.Now().Before(deadline) {
+		select {
+		case <-state.AppContext.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func autoSwitchPaused(state *types.GlobalState) bool {
+	state.AutoSwitchMutex.RLock()
+	defer state.AutoSwitchMutex.RUnlock()
+	return state.AutoSwitchPaused
+}
+
+// SupervisorStatus 返回守护的统计
+func SupervisorStatus(state *types.GlobalState) types.SupervisorStatus {
+	state.SupervisorMutex.RLock()
+	defer state.SupervisorMutex.RUnlock()
+	return state.Supervisor
+}
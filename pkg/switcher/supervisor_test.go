@@ -0,0 +1,163 @@
+//go:build unix
+
+package switcher
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"naiveswitcher/internal/config"
+	"naiveswitcher/internal/sockstest"
+	"naiveswitcher/internal/types"
+	"naiveswitcher/pkg/common"
+)
+
+// fakeNaiveEnv 设置后测试二进制作为模拟的 naive 运行
+const fakeNaiveEnv = "NAIVESWITCHER_FAKE_NAIVE"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(fakeNaiveEnv) != "" {
+		fakeNaive()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// fakeNaive 读取 naive 配置文件，在其中的 listen 地址上提供 SOCKS5 服务，所有 CONNECT 都成功
+func fakeNaive() {
+	if len(os.Args) < 2 {
+		os.Exit(2)
+	}
+	data, err := os.ReadFile(os.Args[1])
+	if err != nil {
+		os.Exit(2)
+	}
+	var c types.NaiveConfig
+	if err := json.Unmarshal(data, &c); err != nil {
+		os.Exit(2)
+	}
+	l, err := net.Listen("tcp", strings.TrimPrefix(c.Listen, "socks://"))
+	if err != nil {
+		os.Exit(1)
+	}
+	sockstest.Run(l, sockstest.Reply(0))
+}
+
+// superviseState 使用测试二进制作为 naive 启动 server，并运行 Supervise
+func superviseState(t *testing.T, cfg *config.Config, server string) (*types.GlobalState, chan types.SwitchRequest) {
+	t.Helper()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Skipf("executable: %v", err)
+	}
+	// 生成的 naive 配置文件写入 BasePath，链接到临时目录避免留在测试二进制旁
+	dir := t.TempDir()
+	if err := os.Symlink(exe, filepath.Join(dir, "naiveproxy-test")); err != nil {
+		t.Skipf("symlink: %v", err)
+	}
+	basePath, binary := common.BasePath, common.Naive
+	common.BasePath, common.Naive = dir, "naiveproxy-test"
+	t.Setenv(fakeNaiveEnv, "1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	state := &types.GlobalState{AppContext: ctx}
+	t.Cleanup(func() {
+		cancel()
+		StopAll(state)
+		common.BasePath, common.Naive = basePath, binary
+	})
+	if err := RestartNaive(state, cfg, server); err != nil {
+		t.Fatalf("start fake naive: %v", err)
+	}
+	doSwitch := make(chan types.SwitchRequest, 1)
+	go Supervise(state, cfg, doSwitch)
+	return state, doSwitch
+}
+
+// crash 终止当前进程，模拟意外退出
+func crash(t *testing.T, state *types.GlobalState) *types.NaiveProcess {
+	t.Helper()
+	p := state.Naive.Load()
+	if err := p.Cmd.Process.Kill(); err != nil {
+		t.Fatal(err)
+	}
+	<-p.Done
+	return p
+}
+
+func TestSuperviseRestartsOnSameServer(t *testing.T) {
+	const server = "https://u:p@a.example.com:443"
+	state, doSwitch := superviseState(t, config.NewConfig("test"), server)
+	p := crash(t, state)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for state.Naive.Load() == p {
+		if time.Now().After(deadline) {
+			t.Fatal("crashed naive was not restarted")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if got := state.Naive.Load(); got.Server != server || got.Exited() {
+		t.Fatalf("restarted process = %+v, want running on %s", got.Status(0), server)
+	}
+	if st := SupervisorStatus(state); st.Restarts != 1 || st.Crashes != 1 || st.LastExit == nil {
+		t.Fatalf("unexpected supervisor status: %+v", st)
+	}
+	select {
+	case req := <-doSwitch:
+		t.Fatalf("unexpected switch request: %+v", req)
+	default:
+	}
+}
+
+func TestSuperviseEscalatesToAvoidAuto(t *testing.T) {
+	const server = "https://u:p@a.example.com:443"
+	base := t.TempDir()
+	data := "supervisor:\n  max_crashes: 1\n  window: 10\n  backoff: 1\n"
+	if err := os.WriteFile(filepath.Join(base, config.DefaultConfigFile), []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.NewConfig("test")
+	if err := cfg.Load(base); err != nil {
+		t.Fatal(err)
+	}
+	state, doSwitch := superviseState(t, cfg, server)
+	p := crash(t, state)
+
+	select {
+	case req := <-doSwitch:
+		if req.Type != "avoid_auto" || req.AvoidServer != server {
+			t.Fatalf("switch request = %+v, want avoid_auto for %s", req, server)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected avoid_auto switch request")
+	}
+	if state.Naive.Load() != p {
+		t.Fatal("supervisor must not restart after escalating")
+	}
+}
+
+func TestSuperviseIgnoresStoppingExit(t *testing.T) {
+	state, doSwitch := superviseState(t, config.NewConfig("test"), "https://u:p@a.example.com:443")
+	p := state.Naive.Load()
+	p.Stopping.Store(true)
+	crash(t, state)
+
+	select {
+	case req := <-doSwitch:
+		t.Fatalf("unexpected switch request: %+v", req)
+	case <-time.After(2500 * time.Millisecond):
+	}
+	if state.Naive.Load() != p {
+		t.Fatal("stopped process must not be restarted")
+	}
+	if st := SupervisorStatus(state); st.Restarts != 0 || st.Crashes != 0 {
+		t.Fatalf("unexpected supervisor status: %+v", st)
+	}
+}
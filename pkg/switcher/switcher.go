@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/url"
 	"sync/atomic"
+	"time"
 
 	"naiveswitcher/internal/config"
 	"naiveswitcher/internal/types"
@@ -32,25 +33,25 @@ func Switcher(state *types.GlobalState, cfg *config.Config, doSwitch <-chan type
 			continue
 		}
 
-		atomic.StoreInt32(&state.ErrorCount, 0)
 		log.DebugF("Switch request: Type=%s, Target=%s, Avoid=%s\n",
 			switchReq.Type, switchReq.TargetServer, switchReq.AvoidServer)
 
 		// 确保有可用的服务器
-		if len(state.HostUrls) == 0 {
-			state.HostUrls = append(state.HostUrls, cfg.BootstrapNode)
+		if len(state.NodeList()) == 0 {
+			state.SetNodes([]subscription.Node{{Name: "bootstrap", URL: cfg.Current().BootstrapNode}})
 		}
 
 		var err error
+		var nodes []subscription.Node
 		switch switchReq.Type {
 		case "select":
-			err = ProcessSelectRequest(state, switchReq)
-		case "avoid":
-			state.HostUrls, err = HandleSwitch(state, cfg, state.HostUrls, switchReq.AvoidServer)
-		case "avoid_auto":
-			state.HostUrls, err = HandleSwitch(state, cfg, state.HostUrls, switchReq.AvoidServer)
+			err = ProcessSelectRequest(state, cfg, switchReq)
+		case "avoid", "avoid_auto":
+			nodes, err = HandleSwitch(state, cfg, state.NodeList(), switchReq.AvoidServer)
+			state.SetNodes(nodes)
 		case "auto":
-			state.HostUrls, err = HandleSwitch(state, cfg, state.HostUrls, "")
+			nodes, err = HandleSwitch(state, cfg, state.NodeList(), "")
+			state.SetNodes(nodes)
 		default:
 			err = fmt.Errorf("unknown switch type: %s", switchReq.Type)
 		}
@@ -63,6 +64,7 @@ func Switcher(state *types.GlobalState, cfg *config.Config, doSwitch <-chan type
 			ps := types.PersistedState{
 				AutoSwitchPaused: state.AutoSwitchPaused,
 				LockedServer:     state.LockedServer,
+				Selector:         state.Selector,
 			}
 			state.AutoSwitchMutex.Unlock()
 			if persistErr := types.SavePersistedState(common.BasePath, ps); persistErr != nil {
@@ -70,7 +72,6 @@ func Switcher(state *types.GlobalState, cfg *config.Config, doSwitch <-chan type
 			}
 		}
 
-		atomic.StoreInt32(&state.ErrorCount, 0)
 		atomic.StoreInt32(&state.Switching, 0) // 重置切换标志
 		log.DebugF("Switching done\n")
 	}
@@ -81,46 +82,92 @@ func isManualSwitchType(t string) bool {
 }
 
 // HandleSwitch 处理服务器切换逻辑
-func HandleSwitch(state *types.GlobalState, cfg *config.Config, oldHostUrls []string, deadServer string) ([]string, error) {
+func HandleSwitch(state *types.GlobalState, cfg *config.Config, oldNodes []subscription.Node, deadServer string) ([]subscription.Node, error) {
+	halfLife := cfg.Current().HealthDecay()
+
 	// 记录故障服务器
 	if deadServer != "" {
 		u, err := url.Parse(deadServer)
 		if err != nil {
 			log.DebugF("Error parsing dead server URL: %v\n", err)
-		} else {
-			state.ServerDownPriorityMutex.Lock()
-			state.ServerDownPriority[u.Hostname()]++
-			state.ServerDownPriorityMutex.Unlock()
+		} else if err := state.Health.RecordFailure(u.Hostname(), halfLife); err != nil {
+			log.DebugF("Save health records error: %v\n", err)
 		}
 	}
 
 	// 获取最新的服务器列表
-	hostUrls, err := subscription.Subscription(cfg.SubscribeURL)
+	nodes := RefreshNodes(state, cfg, false)
+	if len(nodes) == 0 {
+		// 旧列表可能来自重载前的过滤规则，按当前配置重新过滤
+		nodes = candidates(state, cfg.Current(), oldNodes)
+	}
+	if len(nodes) == 0 {
+		return nil, errors.New("no candidate nodes after filtering")
+	}
+	bonus := subscription.PriorityBonus(state.StaticNodes.List(cfg.Current().Nodes))
+
+	selector, err := ActiveSelector(state, cfg, nodes)
 	if err != nil {
-		log.DebugF("Error updating subscription: %v\n", err)
-		hostUrls = oldHostUrls
+		return nil, err
 	}
 
-	// 选择最佳服务器（需要读锁保护）
-	state.ServerDownPriorityMutex.RLock()
-	newFastestUrl, err := subscription.Fastest(hostUrls, state.ServerDownPriority, deadServer)
-	state.ServerDownPriorityMutex.RUnlock()
+	// 测速并记录到健康历史
+	probes, err := subscription.Probe(subscription.URLs(nodes), state.Health.Penalty(halfLife), bonus, deadServer, state.FastestUrl)
+	state.Health.Prune(nodeHosts(nodes))
+	if recordErr := state.Health.RecordProbe(probes, halfLife); recordErr != nil {
+		log.DebugF("Save health records error: %v\n", recordErr)
+	}
+	if probes != nil {
+		state.LastProbeMutex.Lock()
+		state.LastProbe = probes
+		state.LastProbeTime = time.Now().Unix()
+		state.LastProbeMutex.Unlock()
+	}
 	if err != nil {
 		log.DebugF("Error choosing fastest: %v\n", err)
 		return nil, err
 	}
 
-	if state.FastestUrl == newFastestUrl {
-		return hostUrls, errors.New("no change")
+	// 按选择策略挑选服务器
+	newFastestUrl, err := subscription.Select(selector, probes, state.FastestUrl, deadServer)
+	if err != nil {
+		log.DebugF("Error choosing fastest: %v\n", err)
+		return nil, err
+	}
+	log.DebugF("Selected by %s: %s\n", selector.Name(), newFastestUrl)
+
+	// 迟滞：当前节点正常时，提升不够大就不切换
+	state.NaiveCmdLock.Lock()
+	switchedAt := state.SwitchedAt
+	state.NaiveCmdLock.Unlock()
+	decision := decide(cfg.Current().Hysteresis, selector.Name(), probes, state.FastestUrl, newFastestUrl,
+		switchedAt, time.Now(), deadServer != "")
+	recordDecision(state, decision)
+	if !decision.Switch {
+		return nodes, errors.New("no change")
 	}
 
-	log.DebugF("Fastest: %s\n", newFastestUrl)
-
-	// 重启到新服务器
-	if err := RestartNaive(state, newFastestUrl); err != nil {
-		return nil, err
+	// 重启到新服务器，未就绪时保持原服务器并记为一次故障
+	if err := RestartNaive(state, cfg, newFastestUrl); err != nil {
+		if u, parseErr := url.Parse(newFastestUrl); parseErr == nil {
+			if recordErr := state.Health.RecordFailure(u.Hostname(), halfLife); recordErr != nil {
+				log.DebugF("Save health records error: %v\n", recordErr)
+			}
+		}
+		return nodes, err
 	}
 
 	state.FastestUrl = newFastestUrl
-	return hostUrls, nil
+	return nodes, nil
+}
+
+// nodeHosts 返回节点的主机名集合
+func nodeHosts(nodes []subscription.Node) map[string]bool {
+	hosts := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		if u, err := url.Parse(node.URL); err == nil {
+			hosts[u.Hostname()] = true
+		}
+	}
+	return hosts
 }
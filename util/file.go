@@ -0,0 +1,12 @@
+package util
+
+import "os"
+
+// WriteFileAtomic 先写临时文件再重命名，避免写入中断导致文件损坏
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}